@@ -0,0 +1,88 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterKMSSecretSpec defines the desired state of ClusterKMSSecret
+type ClusterKMSSecretSpec struct {
+	// KMSSecretSpec defines the data of the generated Secrets in the same way as KMSSecret.
+	// target.namespaces is ignored, and the namespace of the scope is empty because ClusterKMSSecret is not namespaced.
+	// For the same reason, if region is empty, only the default region of the controller is used, and the region annotation of namespaces is ignored.
+	KMSSecretSpec `json:",inline"`
+	// NamespaceSelector selects the namespaces where the Secret is generated.
+	// An empty selector selects all namespaces.
+	// +kubebuilder:validation:Required
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+}
+
+// NamespaceStatus is the result of the generated Secret in a namespace.
+type NamespaceStatus struct {
+	// Namespace is the namespace of the generated Secret.
+	Namespace string `json:"namespace"`
+	// Synced is true if the Secret in the namespace is up to date.
+	Synced bool `json:"synced"`
+	// Reason is the reason of the failure. It is empty if the Secret is synced.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Message is the sanitized error message. It never contains the decrypted data.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// ClusterKMSSecretStatus defines the observed state of ClusterKMSSecret
+type ClusterKMSSecretStatus struct {
+	KMSSecretStatus `json:",inline"`
+	// Namespaces are the results of the generated Secrets for each selected namespace.
+	// +optional
+	// +listType=map
+	// +listMapKey=namespace
+	Namespaces []NamespaceStatus `json:"namespaces,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].reason"
+// +kubebuilder:printcolumn:name="Last Sync",type="date",JSONPath=".status.lastSyncTime"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ClusterKMSSecret is the Schema for the clusterkmssecrets API
+// It decrypts the data once, and generates the same Secret in every namespace which matches the namespace selector.
+type ClusterKMSSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterKMSSecretSpec   `json:"spec,omitempty"`
+	Status ClusterKMSSecretStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterKMSSecretList contains a list of ClusterKMSSecret
+type ClusterKMSSecretList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterKMSSecret `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterKMSSecret{}, &ClusterKMSSecretList{})
+}
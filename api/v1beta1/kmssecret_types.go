@@ -17,6 +17,7 @@ limitations under the License.
 package v1beta1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -25,18 +26,228 @@ type SecretTemplateSpec struct {
 	// +optional
 	// +kubebuilder:pruning:PreserveUnknownFields
 	metav1.ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+	// Type is the type of the generated Secret, e.g. kubernetes.io/tls. Defaults to Opaque.
+	// The decrypted data are validated with the required keys of the type.
+	// +optional
+	Type corev1.SecretType `json:"type,omitempty"`
+	// Data is Go templates which render the keys of the generated Secret from the decrypted data.
+	// The decrypted data are provided as a map of strings, e.g. {{ .PASSWORD }} or {{ index . "tls.crt" }}.
+	// Rendered keys override the decrypted keys.
+	// +optional
+	Data map[string]string `json:"data,omitempty"`
+}
+
+// SecretCreationPolicy defines how the controller manages the generated Secret.
+// +kubebuilder:validation:Enum=Owner;Merge;Orphan;None
+type SecretCreationPolicy string
+
+const (
+	// CreationPolicyOwner creates the Secret and sets KMSSecret as the controller of it, so the Secret is deleted with KMSSecret.
+	CreationPolicyOwner SecretCreationPolicy = "Owner"
+	// CreationPolicyMerge does not create the Secret, but merges the decrypted data into the existing Secret.
+	CreationPolicyMerge SecretCreationPolicy = "Merge"
+	// CreationPolicyOrphan creates the Secret without owner references, so the Secret is left after KMSSecret is deleted.
+	CreationPolicyOrphan SecretCreationPolicy = "Orphan"
+	// CreationPolicyNone does not create or update the Secret.
+	CreationPolicyNone SecretCreationPolicy = "None"
+)
+
+// ManagedAnnotation is the annotation of Secret which allows KMSSecret to adopt the existing Secret.
+// A Secret which is not controlled by KMSSecret is never overwritten unless it has this annotation with "true".
+const ManagedAnnotation = "secret.h3poteto.dev/managed"
+
+// OwnerAnnotation is the annotation of Secret which records the KMSSecret in namespace/name format, or the name of ClusterKMSSecret.
+// Secrets in other namespaces than the KMSSecret and Secrets in Orphan policy do not have owner references, so they are tracked with this annotation.
+const OwnerAnnotation = "secret.h3poteto.dev/owner"
+
+// RegionAnnotation is the annotation of Namespace which overrides the default region of KMSSecrets in the namespace.
+const RegionAnnotation = "secret.h3poteto.dev/region"
+
+// Finalizer is the finalizer of KMSSecret which deletes the Secrets in other namespaces.
+const Finalizer = "secret.h3poteto.dev/finalizer"
+
+// KMSSecretTarget defines the generated Secret
+type KMSSecretTarget struct {
+	// CreationPolicy defines how the controller manages the generated Secret. Defaults to Owner.
+	// +optional
+	// +kubebuilder:default=Owner
+	CreationPolicy SecretCreationPolicy `json:"creationPolicy,omitempty"`
+	// Name is the name of the generated Secret. Defaults to the name of KMSSecret.
+	// +optional
+	Name string `json:"name,omitempty"`
+	// Namespaces are additional namespaces where the Secret is generated, in addition to the namespace of KMSSecret.
+	// The controller must be started with --allow-cross-namespace-targets to use other namespaces.
+	// +optional
+	// +listType=set
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// Scope defines which KMSSecret can decrypt the ciphertexts.
+// +kubebuilder:validation:Enum=Strict;NamespaceWide;ClusterWide
+type Scope string
+
+const (
+	// ScopeStrict binds ciphertexts to the namespace and the name of KMSSecret, and the key of data.
+	ScopeStrict Scope = "Strict"
+	// ScopeNamespaceWide binds ciphertexts to the namespace of KMSSecret.
+	ScopeNamespaceWide Scope = "NamespaceWide"
+	// ScopeClusterWide does not bind ciphertexts, so any KMSSecret in the cluster can decrypt them.
+	ScopeClusterWide Scope = "ClusterWide"
+)
+
+const (
+	// EncryptionContextPrefix is the prefix of the encryption context keys which are reserved for the scope.
+	EncryptionContextPrefix = "secret.h3poteto.dev/"
+	// EncryptionContextNamespace is the encryption context key of the namespace in Strict and NamespaceWide scope.
+	EncryptionContextNamespace = EncryptionContextPrefix + "namespace"
+	// EncryptionContextName is the encryption context key of the name of KMSSecret in Strict scope.
+	EncryptionContextName = EncryptionContextPrefix + "name"
+	// EncryptionContextKey is the encryption context key of the key of data in Strict scope.
+	EncryptionContextKey = EncryptionContextPrefix + "key"
+)
+
+// FailurePolicy defines how the controller writes the Secret when some keys could not be decrypted.
+// +kubebuilder:validation:Enum=FailAll;SkipFailed;KeepPrevious
+type FailurePolicy string
+
+const (
+	// FailurePolicyFailAll does not write the Secret if any key could not be decrypted.
+	FailurePolicyFailAll FailurePolicy = "FailAll"
+	// FailurePolicySkipFailed writes the Secret with only the keys which are decrypted.
+	FailurePolicySkipFailed FailurePolicy = "SkipFailed"
+	// FailurePolicyKeepPrevious writes the Secret with the keys which are decrypted, and keeps the previous values of the failed keys.
+	FailurePolicyKeepPrevious FailurePolicy = "KeepPrevious"
+)
+
+// Decoding defines how the decrypted plaintext is decoded into the value of Secret.
+// +kubebuilder:validation:Enum=auto;raw;yaml-scalar;base64
+type Decoding string
+
+const (
+	// DecodingAuto parses the plaintext as a YAML string, and uses the plaintext as it is if it could not be parsed.
+	DecodingAuto Decoding = "auto"
+	// DecodingRaw uses the plaintext as it is.
+	DecodingRaw Decoding = "raw"
+	// DecodingYAMLScalar parses the plaintext as a YAML scalar, and fails if it could not be parsed.
+	DecodingYAMLScalar Decoding = "yaml-scalar"
+	// DecodingBase64 decodes the plaintext as standard base64.
+	DecodingBase64 Decoding = "base64"
+)
+
+// DocumentFormat defines the format of an encrypted document.
+// +kubebuilder:validation:Enum=yaml;json;dotenv
+type DocumentFormat string
+
+const (
+	// DocumentFormatYAML is a YAML mapping.
+	DocumentFormatYAML DocumentFormat = "yaml"
+	// DocumentFormatJSON is a JSON object.
+	DocumentFormatJSON DocumentFormat = "json"
+	// DocumentFormatDotenv is lines of KEY=VALUE.
+	DocumentFormatDotenv DocumentFormat = "dotenv"
+)
+
+// EncryptedDataFrom defines an encrypted document which is expanded into multiple keys of Secret
+type EncryptedDataFrom struct {
+	// Name identifies the document. It is used as the key of data for DataOptions, the scope and the status,
+	// so it must be unique in encryptedData and encryptedDataFrom.
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+	// EncryptedData is the encrypted document.
+	// +kubebuilder:validation:Required
+	EncryptedData []byte `json:"encryptedData"`
+	// Format is the format of the document. Defaults to yaml.
+	// +optional
+	// +kubebuilder:default=yaml
+	Format DocumentFormat `json:"format,omitempty"`
+	// Prefix is added to every key which is expanded from the document.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+	// Keys are the keys of the document to expand. If it is empty, all keys are expanded.
+	// +optional
+	Keys []string `json:"keys,omitempty"`
+}
+
+// DataOptions defines options to decrypt a key of EncryptedData or a document of EncryptedDataFrom
+type DataOptions struct {
+	// EncryptionContext is merged over spec.encryptionContext to decrypt the key.
+	// +optional
+	EncryptionContext map[string]string `json:"encryptionContext,omitempty"`
+	// Decoding overrides spec.decoding for the key.
+	// +optional
+	Decoding Decoding `json:"decoding,omitempty"`
 }
 
 // KMSSecretSpec defines the desired state of KMSSecret
+// +kubebuilder:validation:XValidation:rule="!has(self.data) || !has(self.stringData) || self.data.all(k, !(k in self.stringData))",message="data and stringData must not have the same key"
+// +kubebuilder:validation:XValidation:rule="!has(self.encryptedData) || !has(self.data) || self.data.all(k, !(k in self.encryptedData))",message="data and encryptedData must not have the same key"
+// +kubebuilder:validation:XValidation:rule="!has(self.encryptedData) || !has(self.stringData) || self.stringData.all(k, !(k in self.encryptedData))",message="stringData and encryptedData must not have the same key"
 type KMSSecretSpec struct {
 	// +optional
 	Template SecretTemplateSpec `json:"template"`
+	// +optional
+	Target KMSSecretTarget `json:"target,omitempty"`
 
-	// +kubebuilder:validation:Required
-	EncryptedData map[string][]byte `json:"encryptedData"`
-	// +kubebuilder:validation:Required
+	// +optional
+	EncryptedData map[string][]byte `json:"encryptedData,omitempty"`
+	// EncryptedDataFrom is encrypted documents which are expanded into multiple keys of Secret.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	EncryptedDataFrom []EncryptedDataFrom `json:"encryptedDataFrom,omitempty"`
+	// Data is plaintext values which are merged into the generated Secret without decryption.
+	// Values are base64 encoded like data of Secret. Do not put sensitive values here.
+	// +optional
+	Data map[string][]byte `json:"data,omitempty"`
+	// StringData is plaintext values which are merged into the generated Secret without decryption.
+	// It overrides the same keys of Data. Do not put sensitive values here.
+	// +optional
+	StringData map[string]string `json:"stringData,omitempty"`
+	// Region is the region of KMS which encrypted the data.
+	// If it is empty, the region annotation of the namespace or the default region of the controller is used.
+	// +optional
 	// +kubebuilder:validation:Type:=string
-	Region string `json:"region"`
+	Region string `json:"region,omitempty"`
+	// KeyID is the key which must be used to decrypt EncryptedData. It accepts a key ID, a key ARN, an alias name or an alias ARN.
+	// Ciphertexts which are encrypted with other keys are rejected.
+	// +optional
+	KeyID string `json:"keyID,omitempty"`
+	// EncryptionContext is passed to KMS to decrypt every key of EncryptedData.
+	// It must be the same as the encryption context which is used to encrypt the data.
+	// +optional
+	EncryptionContext map[string]string `json:"encryptionContext,omitempty"`
+	// Scope binds the ciphertexts to this KMSSecret. The controller adds the namespace, the name and the key of data
+	// to the encryption context according to the scope, so the data must be encrypted with the same context.
+	// Defaults to ClusterWide.
+	// +optional
+	// +kubebuilder:default=ClusterWide
+	Scope Scope `json:"scope,omitempty"`
+	// FailurePolicy defines how the controller writes the Secret when some keys could not be decrypted. Defaults to FailAll.
+	// +optional
+	// +kubebuilder:default=FailAll
+	FailurePolicy FailurePolicy `json:"failurePolicy,omitempty"`
+	// Decoding defines how the decrypted plaintext is decoded into the value of Secret. Defaults to auto.
+	// +optional
+	// +kubebuilder:default=auto
+	Decoding Decoding `json:"decoding,omitempty"`
+	// DataOptions overrides the options for each key of EncryptedData, or each name of EncryptedDataFrom.
+	// Decoding is not applied to EncryptedDataFrom.
+	// +optional
+	DataOptions map[string]DataOptions `json:"dataOptions,omitempty"`
+}
+
+// DataStatus is the result of decryption for a key of data
+type DataStatus struct {
+	// Key is the key of data.
+	Key string `json:"key"`
+	// Decrypted is true if the key is decrypted.
+	Decrypted bool `json:"decrypted"`
+	// Reason is the class of the error, e.g. AccessDeniedException. It is empty if the key is decrypted.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Message is the error message. It never contains the plaintext.
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 // KMSSecretStatus defines the observed state of KMSSecret
@@ -44,9 +255,73 @@ type KMSSecretStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 	SecretsSum string `json:"secretsSum,omitempty"`
+	// ObservedGeneration is the most recent generation observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastSyncTime is the last time the generated Secret was written by the controller.
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// LastError is the error of the last reconciliation. It is empty if the reconciliation succeeded.
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+	// Data is the result of decryption for each key of data.
+	// +optional
+	// +listType=map
+	// +listMapKey=key
+	Data []DataStatus `json:"data,omitempty"`
+	// Secrets are the Secrets which are generated by the KMSSecret.
+	// +optional
+	Secrets []corev1.SecretReference `json:"secrets,omitempty"`
+	// Conditions represent the latest available observations of the KMSSecret.
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
+const (
+	// ConditionReady indicates that the generated Secret is up to date with the KMSSecret.
+	ConditionReady = "Ready"
+	// ConditionDecrypted indicates that all encrypted data are decrypted.
+	ConditionDecrypted = "Decrypted"
+	// ConditionSecretSynced indicates that the generated Secret is created or updated.
+	ConditionSecretSynced = "SecretSynced"
+	// ConditionStalled indicates that the decryption failed permanently, e.g. the key is disabled or the ciphertext is malformed.
+	// The reconciliation is retried slowly until the KMSSecret or the key is fixed.
+	ConditionStalled = "Stalled"
+)
+
+const (
+	// ReasonSucceeded is the reason of conditions when the reconciliation succeeded.
+	ReasonSucceeded = "Succeeded"
+	// ReasonDecryptFailed is the reason of conditions when the encrypted data could not be decrypted.
+	ReasonDecryptFailed = "DecryptFailed"
+	// ReasonTimeout is the reason of conditions when the decryption did not finish in time.
+	ReasonTimeout = "Timeout"
+	// ReasonSyncFailed is the reason of conditions when the generated Secret could not be written.
+	ReasonSyncFailed = "SyncFailed"
+	// ReasonConflict is the reason of conditions when the Secret exists but it is not managed by the KMSSecret.
+	ReasonConflict = "Conflict"
+	// ReasonSecretNotFound is the reason of conditions when the Secret to merge does not exist.
+	ReasonSecretNotFound = "SecretNotFound"
+	// ReasonInvalidSecretData is the reason of conditions when the decrypted data do not satisfy the type of Secret.
+	ReasonInvalidSecretData = "InvalidSecretData"
+	// ReasonTargetNotAllowed is the reason of conditions when the target namespaces are not allowed.
+	ReasonTargetNotAllowed = "TargetNotAllowed"
+	// ReasonTemplateFailed is the reason of conditions when the template data could not be rendered.
+	ReasonTemplateFailed = "TemplateFailed"
+	// ReasonSkipped is the reason of conditions when the Secret is not managed because of the creation policy.
+	ReasonSkipped = "Skipped"
+)
+
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].reason"
+// +kubebuilder:printcolumn:name="Last Sync",type="date",JSONPath=".status.lastSyncTime"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // KMSSecret is the Schema for the kmssecrets API
 type KMSSecret struct {
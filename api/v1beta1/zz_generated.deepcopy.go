@@ -22,16 +22,177 @@ limitations under the License.
 package v1beta1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterKMSSecret) DeepCopyInto(out *ClusterKMSSecret) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterKMSSecret.
+func (in *ClusterKMSSecret) DeepCopy() *ClusterKMSSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterKMSSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterKMSSecret) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterKMSSecretList) DeepCopyInto(out *ClusterKMSSecretList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ClusterKMSSecret, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterKMSSecretList.
+func (in *ClusterKMSSecretList) DeepCopy() *ClusterKMSSecretList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterKMSSecretList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterKMSSecretList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterKMSSecretSpec) DeepCopyInto(out *ClusterKMSSecretSpec) {
+	*out = *in
+	in.KMSSecretSpec.DeepCopyInto(&out.KMSSecretSpec)
+	in.NamespaceSelector.DeepCopyInto(&out.NamespaceSelector)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterKMSSecretSpec.
+func (in *ClusterKMSSecretSpec) DeepCopy() *ClusterKMSSecretSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterKMSSecretSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterKMSSecretStatus) DeepCopyInto(out *ClusterKMSSecretStatus) {
+	*out = *in
+	in.KMSSecretStatus.DeepCopyInto(&out.KMSSecretStatus)
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]NamespaceStatus, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterKMSSecretStatus.
+func (in *ClusterKMSSecretStatus) DeepCopy() *ClusterKMSSecretStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterKMSSecretStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DataOptions) DeepCopyInto(out *DataOptions) {
+	*out = *in
+	if in.EncryptionContext != nil {
+		in, out := &in.EncryptionContext, &out.EncryptionContext
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DataOptions.
+func (in *DataOptions) DeepCopy() *DataOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(DataOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DataStatus) DeepCopyInto(out *DataStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DataStatus.
+func (in *DataStatus) DeepCopy() *DataStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DataStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EncryptedDataFrom) DeepCopyInto(out *EncryptedDataFrom) {
+	*out = *in
+	if in.EncryptedData != nil {
+		in, out := &in.EncryptedData, &out.EncryptedData
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EncryptedDataFrom.
+func (in *EncryptedDataFrom) DeepCopy() *EncryptedDataFrom {
+	if in == nil {
+		return nil
+	}
+	out := new(EncryptedDataFrom)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KMSSecret) DeepCopyInto(out *KMSSecret) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KMSSecret.
@@ -88,6 +249,7 @@ func (in *KMSSecretList) DeepCopyObject() runtime.Object {
 func (in *KMSSecretSpec) DeepCopyInto(out *KMSSecretSpec) {
 	*out = *in
 	in.Template.DeepCopyInto(&out.Template)
+	in.Target.DeepCopyInto(&out.Target)
 	if in.EncryptedData != nil {
 		in, out := &in.EncryptedData, &out.EncryptedData
 		*out = make(map[string][]byte, len(*in))
@@ -103,6 +265,49 @@ func (in *KMSSecretSpec) DeepCopyInto(out *KMSSecretSpec) {
 			(*out)[key] = outVal
 		}
 	}
+	if in.EncryptedDataFrom != nil {
+		in, out := &in.EncryptedDataFrom, &out.EncryptedDataFrom
+		*out = make([]EncryptedDataFrom, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Data != nil {
+		in, out := &in.Data, &out.Data
+		*out = make(map[string][]byte, len(*in))
+		for key, val := range *in {
+			var outVal []byte
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = make([]byte, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.StringData != nil {
+		in, out := &in.StringData, &out.StringData
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.EncryptionContext != nil {
+		in, out := &in.EncryptionContext, &out.EncryptionContext
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.DataOptions != nil {
+		in, out := &in.DataOptions, &out.DataOptions
+		*out = make(map[string]DataOptions, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KMSSecretSpec.
@@ -118,6 +323,27 @@ func (in *KMSSecretSpec) DeepCopy() *KMSSecretSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KMSSecretStatus) DeepCopyInto(out *KMSSecretStatus) {
 	*out = *in
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Data != nil {
+		in, out := &in.Data, &out.Data
+		*out = make([]DataStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Secrets != nil {
+		in, out := &in.Secrets, &out.Secrets
+		*out = make([]corev1.SecretReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KMSSecretStatus.
@@ -130,10 +356,52 @@ func (in *KMSSecretStatus) DeepCopy() *KMSSecretStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KMSSecretTarget) DeepCopyInto(out *KMSSecretTarget) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KMSSecretTarget.
+func (in *KMSSecretTarget) DeepCopy() *KMSSecretTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(KMSSecretTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespaceStatus) DeepCopyInto(out *NamespaceStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespaceStatus.
+func (in *NamespaceStatus) DeepCopy() *NamespaceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespaceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SecretTemplateSpec) DeepCopyInto(out *SecretTemplateSpec) {
 	*out = *in
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if in.Data != nil {
+		in, out := &in.Data, &out.Data
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretTemplateSpec.
@@ -0,0 +1,315 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/h3poteto/controller-klog/pkg/ctrklog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
+	"github.com/h3poteto/kms-secrets/pkg/decrypter"
+)
+
+// ClusterKMSSecretReconciler reconciles a ClusterKMSSecret object
+type ClusterKMSSecretReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// Decrypter decrypts encryptedData of ClusterKMSSecret.
+	Decrypter decrypter.Decrypter
+	// DefaultRegion is the region of ClusterKMSSecrets which do not specify the region.
+	DefaultRegion string
+	// DecryptParallelism is the number of keys which are decrypted concurrently in a reconciliation.
+	DecryptParallelism int
+	// DecryptTimeout bounds all decryptions in a reconciliation. If it is zero, decryptions are bound only by the reconciliation.
+	DecryptTimeout time.Duration
+	// PermanentFailureRequeueInterval is the interval to retry decryptions which failed permanently.
+	PermanentFailureRequeueInterval time.Duration
+	// MaxConcurrentReconciles is the number of ClusterKMSSecrets which are reconciled concurrently.
+	MaxConcurrentReconciles int
+
+	// backoff is the backoff of throttled decryptions for each object.
+	backoff requeueBackoff
+}
+
+// +kubebuilder:rbac:groups=secret.h3poteto.dev,resources=clusterkmssecrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=secret.h3poteto.dev,resources=clusterkmssecrets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=secret.h3poteto.dev,resources=clusterkmssecrets/finalizers,verbs=update
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+
+func (r *ClusterKMSSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ctx = ctrklog.SetController(ctx, "clusterkmssecret")
+	ctrklog.Info(ctx, "fetching ClusterKMSSecret resources")
+
+	cluster := secretv1beta1.ClusterKMSSecret{}
+	if err := r.Client.Get(ctx, req.NamespacedName, &cluster); err != nil {
+		ctrklog.Errorf(ctx, "failed to get ClusterKMSSecret: %v", err)
+		if apierrors.IsNotFound(err) {
+			forgetStatusMetrics("ClusterKMSSecret", "", req.Name)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	// Secrets have owner references to the ClusterKMSSecret, so they are deleted by the garbage collector.
+	if !cluster.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	ctx = ctrklog.SetObject(ctx, cluster.Name)
+
+	original := cluster.DeepCopy()
+	syncErr := r.syncSecrets(ctx, &cluster)
+	cluster.Status.ObservedGeneration = cluster.Generation
+	cluster.Status.LastError = sanitizeError(syncErr)
+	recordStatusMetrics("ClusterKMSSecret", "", cluster.Name, &cluster.Status.KMSSecretStatus, syncErr == nil)
+	if err := r.updateStatus(ctx, original, &cluster); err != nil {
+		ctrklog.Errorf(ctx, "failed to update ClusterKMSSecret %s: %v", cluster.Name, err)
+		return ctrl.Result{}, err
+	}
+	if syncErr != nil {
+		return requeueResult(&r.backoff, req.NamespacedName, syncErr, r.PermanentFailureRequeueInterval)
+	}
+
+	ctrklog.Info(ctx, "resource status synced")
+
+	return requeueResult(&r.backoff, req.NamespacedName, nil, r.PermanentFailureRequeueInterval)
+}
+
+// syncSecrets decrypts the ClusterKMSSecret once, and creates or updates the Secrets in the selected namespaces.
+// The decryption and the conditions are shared with KMSSecret through the KMSSecret which is converted from the ClusterKMSSecret.
+func (r *ClusterKMSSecretReconciler) syncSecrets(ctx context.Context, cluster *secretv1beta1.ClusterKMSSecret) error {
+	kind := clusterKMSSecretView(cluster)
+	defer func() {
+		cluster.Status.KMSSecretStatus = kind.Status
+	}()
+
+	// ClusterKMSSecret is not namespaced, so the region annotation of namespaces is not used.
+	d := &secretDecrypter{
+		client:        r.Client,
+		recorder:      r.Recorder,
+		decrypter:     r.Decrypter,
+		defaultRegion: r.DefaultRegion,
+		parallelism:   r.DecryptParallelism,
+		timeout:       r.DecryptTimeout,
+	}
+	return d.decryptAndWrite(ctx, cluster, kind, func(decryptedData map[string][]byte, failed map[string]error) error {
+		return r.writeSecrets(ctx, cluster, kind, decryptedData, failed)
+	})
+}
+
+// writeSecrets writes the Secret into every selected namespace, and records the result of each namespace in the status.
+// Secrets in namespaces which are not selected anymore are deleted in Owner policy.
+func (r *ClusterKMSSecretReconciler) writeSecrets(ctx context.Context, cluster *secretv1beta1.ClusterKMSSecret, kind *secretv1beta1.KMSSecret, decryptedData map[string][]byte, failed map[string]error) error {
+	policy := creationPolicy(kind)
+	if policy == secretv1beta1.CreationPolicyNone {
+		ctrklog.Info(ctx, "creationPolicy is None, so skip managing Secret")
+		kind.Status.SecretsSum = shasumData(decryptedData)
+		cluster.Status.Namespaces = nil
+		markSkipped(kind)
+		return nil
+	}
+
+	namespaces, err := r.selectedNamespaces(ctx, cluster)
+	if err != nil {
+		ctrklog.Errorf(ctx, "failed to list namespaces: %v", err)
+		markSyncFailed(kind, secretv1beta1.ReasonSyncFailed, err)
+		return err
+	}
+
+	name := cluster.Spec.Target.Name
+	if name == "" {
+		name = cluster.Name
+	}
+	w := &secretWriter{client: r.Client, recorder: r.Recorder}
+	targets := make([]corev1.SecretReference, 0, len(namespaces))
+	statuses := make([]secretv1beta1.NamespaceStatus, 0, len(namespaces))
+	var syncErr *syncError
+	var shasum string
+	failedCount := 0
+	written := false
+	for _, ns := range namespaces {
+		target := corev1.SecretReference{Namespace: ns, Name: name}
+		targets = append(targets, target)
+		sum, wrote, err := w.writeSecret(ctx, cluster, namespacedView(kind, ns), target, policy, decryptedData, failed)
+		if err != nil {
+			failedCount++
+			var serr *syncError
+			if !errors.As(err, &serr) {
+				serr = &syncError{reason: secretv1beta1.ReasonSyncFailed, err: err}
+			}
+			if syncErr == nil {
+				syncErr = serr
+			}
+			statuses = append(statuses, secretv1beta1.NamespaceStatus{
+				Namespace: ns,
+				Reason:    serr.reason,
+				Message:   sanitizeError(serr.err),
+			})
+			continue
+		}
+		if shasum == "" {
+			shasum = sum
+		}
+		written = written || wrote
+		statuses = append(statuses, secretv1beta1.NamespaceStatus{Namespace: ns, Synced: true})
+	}
+	cluster.Status.Namespaces = statuses
+
+	ownerOf := func(namespace string) *secretv1beta1.KMSSecret { return namespacedView(kind, namespace) }
+	if err := w.cleanupSecrets(ctx, cluster, ownerOf, kind.Status.Secrets, targets, policy); err != nil {
+		// Keep the previous Secrets in the status, so they are deleted in the next reconciliation.
+		kind.Status.Secrets = mergeSecretReferences(kind.Status.Secrets, targets)
+		if syncErr == nil {
+			syncErr = &syncError{reason: secretv1beta1.ReasonSyncFailed, err: err}
+		}
+	} else {
+		kind.Status.Secrets = targets
+	}
+
+	if syncErr != nil {
+		err := syncErr.err
+		if failedCount > 0 {
+			err = fmt.Errorf("failed to sync %d of %d namespaces: %w", failedCount, len(namespaces), syncErr.err)
+		}
+		markSyncFailed(kind, syncErr.reason, err)
+		return err
+	}
+	if shasum != "" {
+		kind.Status.SecretsSum = shasum
+	}
+	markSynced(kind, written || kind.Status.LastSyncTime == nil)
+	return nil
+}
+
+// selectedNamespaces returns the names of namespaces which match the namespace selector, which are sorted by the name.
+// Terminating namespaces are excluded, because Secrets can not be created in them.
+func (r *ClusterKMSSecretReconciler) selectedNamespaces(ctx context.Context, cluster *secretv1beta1.ClusterKMSSecret) ([]string, error) {
+	selector, err := metav1.LabelSelectorAsSelector(&cluster.Spec.NamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespaceSelector: %w", err)
+	}
+	list := corev1.NamespaceList{}
+	if err := r.Client.List(ctx, &list, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	namespaces := make([]string, 0, len(list.Items))
+	for _, ns := range list.Items {
+		if ns.Status.Phase == corev1.NamespaceTerminating {
+			continue
+		}
+		namespaces = append(namespaces, ns.Name)
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// updateStatus writes the status of cluster through the status subresource if it is changed from original.
+func (r *ClusterKMSSecretReconciler) updateStatus(ctx context.Context, original, cluster *secretv1beta1.ClusterKMSSecret) error {
+	if equality.Semantic.DeepEqual(original.Status, cluster.Status) {
+		return nil
+	}
+	err := writeStatus(ctx, r.Client, cluster, func(latest client.Object) {
+		latest.(*secretv1beta1.ClusterKMSSecret).Status = cluster.Status
+	})
+	if err != nil {
+		return err
+	}
+	ctrklog.Infof(ctx, "updated ClusterKMSSecret resource status %s", cluster.Name)
+	return nil
+}
+
+// namespaceRequests enqueues all ClusterKMSSecrets when a namespace is changed.
+// A namespace may stop matching the selector, so the ClusterKMSSecrets which do not match it are also enqueued.
+func (r *ClusterKMSSecretReconciler) namespaceRequests(obj client.Object) []reconcile.Request {
+	ctx := ctrklog.SetController(context.Background(), "clusterkmssecret")
+	list := secretv1beta1.ClusterKMSSecretList{}
+	if err := r.Client.List(ctx, &list); err != nil {
+		ctrklog.Errorf(ctx, "failed to list ClusterKMSSecrets for namespace %s: %v", obj.GetName(), err)
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(list.Items))
+	for _, cluster := range list.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&cluster)})
+	}
+	return requests
+}
+
+func (r *ClusterKMSSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&secretv1beta1.ClusterKMSSecret{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Owns(&corev1.Secret{}).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.namespaceRequests), builder.WithPredicates(namespaceLabelsChanged)).
+		Complete(r)
+}
+
+// namespaceLabelsChanged passes creations, deletions and label changes of namespaces, which may change the selected namespaces.
+// Other updates, e.g. annotations and the status, are ignored, because every ClusterKMSSecret is decrypted again for each event.
+var namespaceLabelsChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return !equality.Semantic.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+	},
+	GenericFunc: func(e event.GenericEvent) bool {
+		return false
+	},
+}
+
+// clusterKMSSecretView converts the ClusterKMSSecret into a KMSSecret, so the decryption and the conditions of KMSSecret can be reused.
+// It has the kind and the UID of the ClusterKMSSecret, so the generated Secrets refer to the ClusterKMSSecret as the owner.
+func clusterKMSSecretView(cluster *secretv1beta1.ClusterKMSSecret) *secretv1beta1.KMSSecret {
+	return &secretv1beta1.KMSSecret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: secretv1beta1.GroupVersion.String(),
+			Kind:       "ClusterKMSSecret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       cluster.Name,
+			UID:        cluster.UID,
+			Generation: cluster.Generation,
+		},
+		Spec:   *cluster.Spec.KMSSecretSpec.DeepCopy(),
+		Status: *cluster.Status.KMSSecretStatus.DeepCopy(),
+	}
+}
+
+// namespacedView returns a copy of the converted KMSSecret in the namespace, so the Secret in the namespace has the owner reference.
+func namespacedView(kind *secretv1beta1.KMSSecret, namespace string) *secretv1beta1.KMSSecret {
+	view := kind.DeepCopy()
+	view.Namespace = namespace
+	return view
+}
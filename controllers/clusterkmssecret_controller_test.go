@@ -0,0 +1,306 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
+	"github.com/h3poteto/kms-secrets/pkg/decrypter"
+)
+
+func newTestClusterReconciler(t *testing.T, d decrypter.Decrypter, objs ...client.Object) *ClusterKMSSecretReconciler {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = secretv1beta1.AddToScheme(scheme)
+	return &ClusterKMSSecretReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		Scheme:    scheme,
+		Recorder:  record.NewFakeRecorder(100),
+		Decrypter: d,
+	}
+}
+
+func newTestNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+}
+
+func TestReconcileClusterKMSSecret(t *testing.T) {
+	d := decrypter.NewFakeDecrypter()
+	d.Add([]byte("encrypted-hoge"), []byte("hoge"))
+	cluster := &secretv1beta1.ClusterKMSSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			UID:  "cluster-uid",
+		},
+		Spec: secretv1beta1.ClusterKMSSecretSpec{
+			KMSSecretSpec: secretv1beta1.KMSSecretSpec{
+				EncryptedData: map[string][]byte{"API_KEY": []byte("encrypted-hoge")},
+				Region:        "us-east-1",
+			},
+			NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "web"}},
+		},
+	}
+	r := newTestClusterReconciler(t, d,
+		cluster,
+		newTestNamespace("web1", map[string]string{"team": "web"}),
+		newTestNamespace("web2", map[string]string{"team": "web"}),
+		newTestNamespace("db", map[string]string{"team": "db"}),
+	)
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(cluster)}
+
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	for _, ns := range []string{"web1", "web2"} {
+		secret := corev1.Secret{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ns, Name: "test"}, &secret); err != nil {
+			t.Fatal(err)
+		}
+		if string(secret.Data["API_KEY"]) != "hoge" {
+			t.Errorf("API_KEY in %s is not matched, expected: hoge, returned: %s", ns, secret.Data["API_KEY"])
+		}
+		ref := metav1.GetControllerOf(&secret)
+		if ref == nil || ref.Kind != "ClusterKMSSecret" || ref.UID != cluster.UID {
+			t.Errorf("Secret in %s is not controlled by ClusterKMSSecret: %#v", ns, ref)
+		}
+	}
+	secret := corev1.Secret{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: "db", Name: "test"}, &secret); !apierrors.IsNotFound(err) {
+		t.Errorf("Secret should not be created in namespaces which are not selected: %v", err)
+	}
+	if d.Calls() != 1 {
+		t.Errorf("ClusterKMSSecret should be decrypted once, but decrypted %d times", d.Calls())
+	}
+
+	res := secretv1beta1.ClusterKMSSecret{}
+	if err := r.Client.Get(ctx, req.NamespacedName, &res); err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsStatusConditionTrue(res.Status.Conditions, secretv1beta1.ConditionReady) {
+		t.Errorf("Ready condition is not true: %#v", res.Status.Conditions)
+	}
+	if len(res.Status.Namespaces) != 2 || !res.Status.Namespaces[0].Synced || !res.Status.Namespaces[1].Synced {
+		t.Errorf("namespaces in status are not matched: %#v", res.Status.Namespaces)
+	}
+	if reqs := r.namespaceRequests(newTestNamespace("web3", nil)); len(reqs) != 1 || reqs[0].NamespacedName != req.NamespacedName {
+		t.Errorf("ClusterKMSSecret is not enqueued for namespaces: %v", reqs)
+	}
+
+	// Secrets in namespaces which are not selected anymore are deleted.
+	web2 := corev1.Namespace{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: "web2"}, &web2); err != nil {
+		t.Fatal(err)
+	}
+	web2.Labels = nil
+	if err := r.Client.Update(ctx, &web2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: "web2", Name: "test"}, &secret); !apierrors.IsNotFound(err) {
+		t.Errorf("Secret in unselected namespace should be deleted: %v", err)
+	}
+	if err := r.Client.Get(ctx, req.NamespacedName, &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Status.Secrets) != 1 || res.Status.Secrets[0].Namespace != "web1" {
+		t.Errorf("Secrets in status are not matched: %v", res.Status.Secrets)
+	}
+}
+
+func TestReconcileClusterKMSSecretConflict(t *testing.T) {
+	d := decrypter.NewFakeDecrypter()
+	d.Add([]byte("encrypted-hoge"), []byte("hoge"))
+	cluster := &secretv1beta1.ClusterKMSSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			UID:  "cluster-uid",
+		},
+		Spec: secretv1beta1.ClusterKMSSecretSpec{
+			KMSSecretSpec: secretv1beta1.KMSSecretSpec{
+				EncryptedData: map[string][]byte{"API_KEY": []byte("encrypted-hoge")},
+				Region:        "us-east-1",
+			},
+		},
+	}
+	foreign := newTestSecret(nil, map[string]string{"API_KEY": "fuga"})
+	foreign.Namespace = "web2"
+	r := newTestClusterReconciler(t, d,
+		cluster,
+		foreign,
+		newTestNamespace("web1", nil),
+		newTestNamespace("web2", nil),
+	)
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(cluster)}
+
+	if _, err := r.Reconcile(ctx, req); err == nil {
+		t.Fatalf("Reconcile should return an error when a Secret conflicts")
+	}
+	res := secretv1beta1.ClusterKMSSecret{}
+	if err := r.Client.Get(ctx, req.NamespacedName, &res); err != nil {
+		t.Fatal(err)
+	}
+	expected := []secretv1beta1.NamespaceStatus{
+		{Namespace: "web1", Synced: true},
+		{Namespace: "web2", Reason: secretv1beta1.ReasonConflict},
+	}
+	if len(res.Status.Namespaces) != len(expected) {
+		t.Fatalf("namespaces in status are not matched: %#v", res.Status.Namespaces)
+	}
+	for i, e := range expected {
+		status := res.Status.Namespaces[i]
+		if status.Namespace != e.Namespace || status.Synced != e.Synced || status.Reason != e.Reason {
+			t.Errorf("status of %s is not matched: %#v", e.Namespace, status)
+		}
+	}
+	if cond := meta.FindStatusCondition(res.Status.Conditions, secretv1beta1.ConditionReady); cond == nil || cond.Reason != secretv1beta1.ReasonConflict {
+		t.Errorf("Ready condition is not matched: %#v", cond)
+	}
+}
+
+func TestReconcileClusterKMSSecretDecryptFailed(t *testing.T) {
+	d := decrypter.NewFakeDecrypter()
+	d.Add([]byte("encrypted-hoge"), []byte("hoge"))
+	cluster := &secretv1beta1.ClusterKMSSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			UID:  "cluster-uid",
+		},
+		Spec: secretv1beta1.ClusterKMSSecretSpec{
+			KMSSecretSpec: secretv1beta1.KMSSecretSpec{
+				EncryptedData: map[string][]byte{
+					"API_KEY":  []byte("encrypted-hoge"),
+					"PASSWORD": []byte("unknown"),
+				},
+				Region: "us-east-1",
+			},
+		},
+	}
+	r := newTestClusterReconciler(t, d, cluster, newTestNamespace("web1", nil))
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(cluster)}
+
+	if _, err := r.Reconcile(ctx, req); err == nil {
+		t.Fatal("Reconcile should return an error")
+	}
+	secret := corev1.Secret{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: "web1", Name: "test"}, &secret); !apierrors.IsNotFound(err) {
+		t.Errorf("Secret should not be created in FailAll policy: %v", err)
+	}
+	res := secretv1beta1.ClusterKMSSecret{}
+	if err := r.Client.Get(ctx, req.NamespacedName, &res); err != nil {
+		t.Fatal(err)
+	}
+	if meta.IsStatusConditionTrue(res.Status.Conditions, secretv1beta1.ConditionDecrypted) {
+		t.Errorf("Decrypted condition should not be true: %#v", res.Status.Conditions)
+	}
+	if len(res.Status.Data) != 2 || !res.Status.Data[0].Decrypted || res.Status.Data[1].Decrypted {
+		t.Errorf("data in status are not matched: %#v", res.Status.Data)
+	}
+}
+
+func TestReconcileClusterKMSSecretMerge(t *testing.T) {
+	d := decrypter.NewFakeDecrypter()
+	d.Add([]byte("encrypted-hoge"), []byte("hoge"))
+	cluster := &secretv1beta1.ClusterKMSSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			UID:  "cluster-uid",
+		},
+		Spec: secretv1beta1.ClusterKMSSecretSpec{
+			KMSSecretSpec: secretv1beta1.KMSSecretSpec{
+				EncryptedData: map[string][]byte{"API_KEY": []byte("encrypted-hoge")},
+				Region:        "us-east-1",
+				Target:        secretv1beta1.KMSSecretTarget{CreationPolicy: secretv1beta1.CreationPolicyMerge},
+			},
+		},
+	}
+	managed := newTestSecret(map[string]string{secretv1beta1.ManagedAnnotation: "true"}, map[string]string{"API_KEY": "fuga"})
+	managed.Namespace = "web1"
+	victim := newTestSecret(nil, map[string]string{"API_KEY": "fuga"})
+	victim.Namespace = "web2"
+	r := newTestClusterReconciler(t, d,
+		cluster,
+		managed,
+		victim,
+		newTestNamespace("web1", nil),
+		newTestNamespace("web2", nil),
+	)
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(cluster)}
+
+	if _, err := r.Reconcile(ctx, req); err == nil {
+		t.Fatalf("Reconcile should return an error when a Secret is not managed")
+	}
+	secret := corev1.Secret{}
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(managed), &secret); err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["API_KEY"]) != "hoge" {
+		t.Errorf("API_KEY of the managed Secret should be merged, but %s", secret.Data["API_KEY"])
+	}
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(victim), &secret); err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["API_KEY"]) != "fuga" {
+		t.Errorf("API_KEY of the Secret which is not managed should not be overwritten, but %s", secret.Data["API_KEY"])
+	}
+	res := secretv1beta1.ClusterKMSSecret{}
+	if err := r.Client.Get(ctx, req.NamespacedName, &res); err != nil {
+		t.Fatal(err)
+	}
+	expected := []secretv1beta1.NamespaceStatus{
+		{Namespace: "web1", Synced: true},
+		{Namespace: "web2", Reason: secretv1beta1.ReasonConflict},
+	}
+	if len(res.Status.Namespaces) != len(expected) {
+		t.Fatalf("namespaces in status are not matched: %#v", res.Status.Namespaces)
+	}
+	for i, e := range expected {
+		status := res.Status.Namespaces[i]
+		if status.Namespace != e.Namespace || status.Synced != e.Synced || status.Reason != e.Reason {
+			t.Errorf("status of %s is not matched: %#v", e.Namespace, status)
+		}
+	}
+}
+
+func TestNamespaceLabelsChanged(t *testing.T) {
+	old := newTestNamespace("web1", map[string]string{"team": "web"})
+	if !namespaceLabelsChanged.Create(event.CreateEvent{Object: old}) || !namespaceLabelsChanged.Delete(event.DeleteEvent{Object: old}) {
+		t.Errorf("creations and deletions of namespaces should be passed")
+	}
+
+	annotated := old.DeepCopy()
+	annotated.Annotations = map[string]string{"example.com/note": "updated"}
+	annotated.Status.Phase = corev1.NamespaceActive
+	if namespaceLabelsChanged.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: annotated}) {
+		t.Errorf("updates of annotations and the status should be ignored")
+	}
+
+	relabeled := old.DeepCopy()
+	relabeled.Labels = map[string]string{"team": "db"}
+	if !namespaceLabelsChanged.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: relabeled}) {
+		t.Errorf("updates of labels should be passed")
+	}
+}
@@ -0,0 +1,360 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/h3poteto/controller-klog/pkg/ctrklog"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
+	"github.com/h3poteto/kms-secrets/pkg/decrypter"
+)
+
+var (
+	errReservedEncryptionContext = errors.New("encryption context is reserved for the scope")
+	errDecode                    = errors.New("failed to decode")
+	errMissingRegion             = errors.New("region is not specified")
+)
+
+// defaultDecryptParallelism is the number of keys which are decrypted concurrently in a reconciliation when it is not specified.
+const defaultDecryptParallelism = 10
+
+// decryptData decrypts every key of encryptedData and every document of encryptedDataFrom in the KMSSecret using the Decrypter.
+// At most parallelism keys and documents are decrypted concurrently.
+// It returns the decrypted data and the errors of the keys which could not be decrypted.
+// Errors of documents are recorded with the name of encryptedDataFrom.
+func decryptData(ctx context.Context, d decrypter.Decrypter, kind *secretv1beta1.KMSSecret, parallelism int) (map[string][]byte, map[string]error) {
+	keys := make([]string, 0, len(kind.Spec.EncryptedData))
+	for key := range kind.Spec.EncryptedData {
+		keys = append(keys, key)
+	}
+	entries := kind.Spec.EncryptedDataFrom
+
+	// Each job writes only its own index, so the results do not need a lock.
+	values := make([][]byte, len(keys))
+	documents := make([]map[string][]byte, len(entries))
+	results := make([]error, len(keys)+len(entries))
+	parallelize(len(keys)+len(entries), parallelism, func(i int) {
+		if i < len(keys) {
+			key := keys[i]
+			plain, err := decryptValue(ctx, d, kind, key, kind.Spec.EncryptedData[key])
+			if err != nil {
+				ctrklog.Errorf(ctx, "failed to decrypt %s: %v", key, err)
+				results[i] = err
+				return
+			}
+			value, err := decodeValue(ctx, key, plain, decoding(kind, key))
+			if err != nil {
+				ctrklog.Errorf(ctx, "failed to decode %s: %v", key, err)
+				results[i] = err
+				return
+			}
+			values[i] = value
+			return
+		}
+		entry := &entries[i-len(keys)]
+		plain, err := decryptValue(ctx, d, kind, entry.Name, entry.EncryptedData)
+		if err != nil {
+			ctrklog.Errorf(ctx, "failed to decrypt %s: %v", entry.Name, err)
+			results[i] = err
+			return
+		}
+		expanded, err := expandDocument(entry, plain)
+		if err != nil {
+			ctrklog.Errorf(ctx, "failed to expand %s: %v", entry.Name, err)
+			results[i] = err
+			return
+		}
+		documents[i-len(keys)] = expanded
+	})
+
+	decryptedData := make(map[string][]byte)
+	errs := make(map[string]error)
+	for i, key := range keys {
+		if results[i] != nil {
+			errs[key] = results[i]
+			continue
+		}
+		decryptedData[key] = values[i]
+	}
+
+	// Keys which are expanded from documents must not overwrite keys of encryptedData or keys of other documents.
+	// Conflicts are checked in the order of encryptedDataFrom, so the result does not depend on the order of decryption.
+	sources := make(map[string]string)
+	for i := range entries {
+		name := entries[i].Name
+		if err := results[len(keys)+i]; err != nil {
+			errs[name] = err
+			continue
+		}
+		if err := documentConflict(kind, name, documents[i], sources); err != nil {
+			ctrklog.Errorf(ctx, "failed to expand %s: %v", name, err)
+			errs[name] = err
+			continue
+		}
+		for key, value := range documents[i] {
+			sources[key] = name
+			decryptedData[key] = value
+		}
+	}
+	return decryptedData, errs
+}
+
+// parallelize calls fn for each index from 0 to n-1 with at most parallelism goroutines, and waits for all of them.
+func parallelize(n, parallelism int, fn func(i int)) {
+	if parallelism <= 0 {
+		parallelism = defaultDecryptParallelism
+	}
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// documentConflict returns an error if a key expanded from the document is already used by encryptedData or by another document.
+func documentConflict(kind *secretv1beta1.KMSSecret, name string, values map[string][]byte, sources map[string]string) error {
+	if _, ok := kind.Spec.EncryptedData[name]; ok {
+		return fmt.Errorf("%w: name %s is also a key of encryptedData", errKeyConflict, name)
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, ok := kind.Spec.EncryptedData[key]; ok {
+			return fmt.Errorf("%w: %s in %s is also a key of encryptedData", errKeyConflict, key, name)
+		}
+		if source, ok := sources[key]; ok {
+			return fmt.Errorf("%w: %s in %s is also expanded from %s", errKeyConflict, key, name, source)
+		}
+	}
+	return nil
+}
+
+// dataCount returns the number of keys of encryptedData and documents of encryptedDataFrom.
+func dataCount(kind *secretv1beta1.KMSSecret) int {
+	return len(kind.Spec.EncryptedData) + len(kind.Spec.EncryptedDataFrom)
+}
+
+func decoding(kind *secretv1beta1.KMSSecret, key string) secretv1beta1.Decoding {
+	if d := kind.Spec.DataOptions[key].Decoding; d != "" {
+		return d
+	}
+	if kind.Spec.Decoding != "" {
+		return kind.Spec.Decoding
+	}
+	return secretv1beta1.DecodingAuto
+}
+
+// decodeValue decodes the plaintext into the value of Secret.
+// Errors never contain the plaintext, because they are recorded in the status.
+func decodeValue(ctx context.Context, key string, plain []byte, decoding secretv1beta1.Decoding) ([]byte, error) {
+	switch decoding {
+	case secretv1beta1.DecodingRaw:
+		return plain, nil
+	case secretv1beta1.DecodingYAMLScalar:
+		value, err := yamlParse(plain)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s is not a YAML scalar", errDecode, key)
+		}
+		return value, nil
+	case secretv1beta1.DecodingBase64:
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(plain)))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s is not base64 encoded", errDecode, key)
+		}
+		return value, nil
+	case secretv1beta1.DecodingAuto:
+		value, err := yamlParse(plain)
+		if err != nil {
+			ctrklog.Warningf(ctx, "failed to yaml parse for %s, so insert plain text", key)
+			return plain, nil
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("%w: unknown decoding %s", errDecode, decoding)
+}
+
+// resolveRegion returns the region to decrypt the KMSSecret.
+// The region of the spec is preferred, then the region annotation of the namespace, and then the default region of the controller.
+func resolveRegion(ctx context.Context, c client.Reader, kind *secretv1beta1.KMSSecret, defaultRegion string) (string, error) {
+	if kind.Spec.Region != "" {
+		return kind.Spec.Region, nil
+	}
+	if kind.Namespace != "" {
+		ns := corev1.Namespace{}
+		if err := c.Get(ctx, client.ObjectKey{Name: kind.Namespace}, &ns); err != nil {
+			return "", fmt.Errorf("failed to get namespace %s: %w", kind.Namespace, err)
+		}
+		if region := ns.Annotations[secretv1beta1.RegionAnnotation]; region != "" {
+			return region, nil
+		}
+	}
+	if defaultRegion != "" {
+		return defaultRegion, nil
+	}
+	return "", fmt.Errorf("%w: set spec.region, the %s annotation of the namespace or --default-region of the controller", errMissingRegion, secretv1beta1.RegionAnnotation)
+}
+
+// withRegion returns the KMSSecret which is decrypted in the region. The KMSSecret is copied if the region is not the same as the spec.
+func withRegion(kind *secretv1beta1.KMSSecret, region string) *secretv1beta1.KMSSecret {
+	if kind.Spec.Region == region {
+		return kind
+	}
+	view := kind.DeepCopy()
+	view.Spec.Region = region
+	return view
+}
+
+func decryptValue(ctx context.Context, d decrypter.Decrypter, kind *secretv1beta1.KMSSecret, key string, ciphertext []byte) ([]byte, error) {
+	encContext, err := encryptionContext(kind, key)
+	if err != nil {
+		return nil, err
+	}
+	input := &decrypter.Input{
+		Region:            kind.Spec.Region,
+		CiphertextBlob:    ciphertext,
+		EncryptionContext: encContext,
+		KeyID:             kind.Spec.KeyID,
+	}
+	decrypted, err := d.Decrypt(ctx, input)
+	if err != nil {
+		if s := scope(kind); s != secretv1beta1.ScopeClusterWide {
+			return nil, fmt.Errorf("failed to decrypt %s in %s scope: %w", key, s, err)
+		}
+		return nil, err
+	}
+	return decrypted.Plaintext, nil
+}
+
+// decryptError aggregates the errors of keys into an error.
+// The error of the first key is wrapped, so the error class can be inspected.
+func decryptError(errs map[string]error, total int) error {
+	keys := make([]string, 0, len(errs))
+	for k := range errs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	// The cause is chosen in the order of timeouts, throttled errors, other errors and permanent errors,
+	// so the error is permanent only if all keys failed permanently.
+	cause := errs[keys[0]]
+	for _, k := range keys[1:] {
+		if causePriority(errs[k]) < causePriority(cause) {
+			cause = errs[k]
+		}
+	}
+	return fmt.Errorf("failed to decrypt %d of %d keys (%s): %w", len(keys), total, strings.Join(keys, ", "), cause)
+}
+
+func causePriority(err error) int {
+	switch {
+	case isTimeout(err):
+		return 0
+	case isThrottled(err):
+		return 1
+	case isPermanent(err):
+		return 3
+	}
+	return 2
+}
+
+// isTimeout returns true if err is caused by the deadline of the context.
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// decryptContext returns the context which bounds all decryptions in a reconciliation.
+// If timeout is zero, decryptions are bound only by ctx.
+func decryptContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func failurePolicy(kind *secretv1beta1.KMSSecret) secretv1beta1.FailurePolicy {
+	if kind.Spec.FailurePolicy == "" {
+		return secretv1beta1.FailurePolicyFailAll
+	}
+	return kind.Spec.FailurePolicy
+}
+
+func scope(kind *secretv1beta1.KMSSecret) secretv1beta1.Scope {
+	if kind.Spec.Scope == "" {
+		return secretv1beta1.ScopeClusterWide
+	}
+	return kind.Spec.Scope
+}
+
+// encryptionContext returns the encryption context to decrypt the key.
+// The encryption context of DataOptions for the key is merged over the global one, and then the context of the scope is added.
+// Users can not specify the keys of the scope, otherwise ciphertexts which are bound to other KMSSecrets can be decrypted.
+func encryptionContext(kind *secretv1beta1.KMSSecret, key string) (map[string]string, error) {
+	override := kind.Spec.DataOptions[key].EncryptionContext
+	res := make(map[string]string, len(kind.Spec.EncryptionContext)+len(override)+3)
+	for _, c := range []map[string]string{kind.Spec.EncryptionContext, override} {
+		for k, v := range c {
+			if strings.HasPrefix(k, secretv1beta1.EncryptionContextPrefix) {
+				return nil, fmt.Errorf("%w: %s", errReservedEncryptionContext, k)
+			}
+			res[k] = v
+		}
+	}
+
+	switch scope(kind) {
+	case secretv1beta1.ScopeStrict:
+		res[secretv1beta1.EncryptionContextNamespace] = kind.Namespace
+		res[secretv1beta1.EncryptionContextName] = kind.Name
+		res[secretv1beta1.EncryptionContextKey] = key
+	case secretv1beta1.ScopeNamespaceWide:
+		res[secretv1beta1.EncryptionContextNamespace] = kind.Namespace
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	return res, nil
+}
+
+func yamlParse(input []byte) ([]byte, error) {
+	var res string
+	if err := yaml.Unmarshal(input, &res); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal: %w", err)
+	}
+	return []byte(res), nil
+}
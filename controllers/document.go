@@ -0,0 +1,181 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
+)
+
+var (
+	errInvalidKey  = errors.New("invalid key")
+	errKeyConflict = errors.New("key conflict")
+)
+
+// expandDocument expands the decrypted document of entry into the keys of Secret.
+// Errors never contain the plaintext, because they are recorded in the status.
+func expandDocument(entry *secretv1beta1.EncryptedDataFrom, plain []byte) (map[string][]byte, error) {
+	var values map[string][]byte
+	var err error
+	switch documentFormat(entry) {
+	case secretv1beta1.DocumentFormatYAML:
+		values, err = parseYAMLDocument(plain)
+	case secretv1beta1.DocumentFormatJSON:
+		values, err = parseJSONDocument(plain)
+	case secretv1beta1.DocumentFormatDotenv:
+		values, err = parseDotenvDocument(plain)
+	default:
+		return nil, fmt.Errorf("%w: unknown format %s", errDecode, entry.Format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s is not a valid %s document: %v", errDecode, entry.Name, documentFormat(entry), err)
+	}
+
+	if len(entry.Keys) > 0 {
+		filtered := make(map[string][]byte, len(entry.Keys))
+		for _, key := range entry.Keys {
+			value, ok := values[key]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s is not found in %s", errInvalidKey, key, entry.Name)
+			}
+			filtered[key] = value
+		}
+		values = filtered
+	}
+
+	res := make(map[string][]byte, len(values))
+	for key, value := range values {
+		name := entry.Prefix + key
+		if errs := validation.IsConfigMapKey(name); len(errs) > 0 {
+			return nil, fmt.Errorf("%w: %s in %s is not a valid key of Secret: %s", errInvalidKey, name, entry.Name, strings.Join(errs, ", "))
+		}
+		res[name] = value
+	}
+	return res, nil
+}
+
+func documentFormat(entry *secretv1beta1.EncryptedDataFrom) secretv1beta1.DocumentFormat {
+	if entry.Format == "" {
+		return secretv1beta1.DocumentFormatYAML
+	}
+	return entry.Format
+}
+
+// parseYAMLDocument parses a YAML mapping. The parser error is not returned, because it may quote the plaintext.
+func parseYAMLDocument(input []byte) (map[string][]byte, error) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(input, &doc); err != nil {
+		return nil, errors.New("failed to unmarshal")
+	}
+	res := make(map[string][]byte, len(doc))
+	for key, value := range doc {
+		v, err := documentValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %s: %w", key, err)
+		}
+		res[key] = v
+	}
+	return res, nil
+}
+
+// parseJSONDocument parses a JSON object. The parser error is not returned, because it may quote the plaintext.
+func parseJSONDocument(input []byte) (map[string][]byte, error) {
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(input))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, errors.New("failed to unmarshal")
+	}
+	res := make(map[string][]byte, len(doc))
+	for key, value := range doc {
+		v, err := documentValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %s: %w", key, err)
+		}
+		res[key] = v
+	}
+	return res, nil
+}
+
+// documentValue converts a value of a document into the value of Secret.
+// Strings are used as is, other scalars are formatted, and mappings and sequences are encoded as JSON.
+func documentValue(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return []byte{}, nil
+	case string:
+		return []byte(v), nil
+	case json.Number:
+		return []byte(v.String()), nil
+	case bool, int, int64, uint64, float64:
+		return []byte(fmt.Sprint(v)), nil
+	}
+	res, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.New("failed to encode as JSON")
+	}
+	return res, nil
+}
+
+// parseDotenvDocument parses lines of KEY=VALUE. Blank lines and comments are ignored, and values can be quoted.
+// Errors only contain the line number, because the line may be the plaintext.
+func parseDotenvDocument(input []byte) (map[string][]byte, error) {
+	res := make(map[string][]byte)
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+		key, value, ok := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d is not KEY=VALUE", line)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 {
+			switch {
+			case value[0] == '"' && value[len(value)-1] == '"':
+				unquoted, err := strconv.Unquote(value)
+				if err != nil {
+					return nil, fmt.Errorf("line %d has an invalid quoted value", line)
+				}
+				value = unquoted
+			case value[0] == '\'' && value[len(value)-1] == '\'':
+				value = value[1 : len(value)-1]
+			}
+		}
+		res[key] = []byte(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New("failed to read lines")
+	}
+	return res, nil
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
+)
+
+func TestExpandDocument(t *testing.T) {
+	cases := []struct {
+		title       string
+		entry       secretv1beta1.EncryptedDataFrom
+		plain       string
+		expected    map[string]string
+		expectError bool
+	}{
+		{
+			title:    "yaml is the default format",
+			entry:    secretv1beta1.EncryptedDataFrom{Name: "config"},
+			plain:    "USER: admin\nPORT: 5432\nDEBUG: yes\n",
+			expected: map[string]string{"USER": "admin", "PORT": "5432", "DEBUG": "yes"},
+		},
+		{
+			title:    "nested yaml is encoded as JSON",
+			entry:    secretv1beta1.EncryptedDataFrom{Name: "config"},
+			plain:    "db:\n  user: admin\n",
+			expected: map[string]string{"db": `{"user":"admin"}`},
+		},
+		{
+			title:    "json keeps numbers as is",
+			entry:    secretv1beta1.EncryptedDataFrom{Name: "config", Format: secretv1beta1.DocumentFormatJSON},
+			plain:    `{"USER": "admin", "PORT": 5432, "RATIO": 0.10, "TLS": true, "EMPTY": null}`,
+			expected: map[string]string{"USER": "admin", "PORT": "5432", "RATIO": "0.10", "TLS": "true", "EMPTY": ""},
+		},
+		{
+			title: "dotenv",
+			entry: secretv1beta1.EncryptedDataFrom{Name: "env", Format: secretv1beta1.DocumentFormatDotenv},
+			plain: "# comment\n\nexport USER=admin\nPASSWORD=\"p@ss\\nword\"\nTOKEN='a=b'\n",
+			expected: map[string]string{
+				"USER":     "admin",
+				"PASSWORD": "p@ss\nword",
+				"TOKEN":    "a=b",
+			},
+		},
+		{
+			title:       "dotenv without =",
+			entry:       secretv1beta1.EncryptedDataFrom{Name: "env", Format: secretv1beta1.DocumentFormatDotenv},
+			plain:       "USER=admin\nsecret-value\n",
+			expectError: true,
+		},
+		{
+			title:    "prefix and keys",
+			entry:    secretv1beta1.EncryptedDataFrom{Name: "config", Prefix: "DB_", Keys: []string{"USER"}},
+			plain:    "USER: admin\nPASSWORD: hoge\n",
+			expected: map[string]string{"DB_USER": "admin"},
+		},
+		{
+			title:       "keys which are not in the document",
+			entry:       secretv1beta1.EncryptedDataFrom{Name: "config", Keys: []string{"HOST"}},
+			plain:       "USER: admin\n",
+			expectError: true,
+		},
+		{
+			title:       "invalid key of Secret",
+			entry:       secretv1beta1.EncryptedDataFrom{Name: "config"},
+			plain:       "db user: admin\n",
+			expectError: true,
+		},
+		{
+			title:       "not a mapping",
+			entry:       secretv1beta1.EncryptedDataFrom{Name: "config", Format: secretv1beta1.DocumentFormatJSON},
+			plain:       `["secret-value"]`,
+			expectError: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			res, err := expandDocument(&c.entry, []byte(c.plain))
+			if c.expectError {
+				if err == nil {
+					t.Fatalf("expandDocument should return an error")
+				}
+				if strings.Contains(err.Error(), "secret-value") {
+					t.Errorf("error contains the plaintext: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res) != len(c.expected) {
+				t.Errorf("keys are not matched, expected: %v, returned: %v", c.expected, res)
+			}
+			for k, v := range c.expected {
+				if string(res[k]) != v {
+					t.Errorf("%s is not matched, expected: %s, returned: %s", k, v, res[k])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/h3poteto/controller-klog/pkg/ctrklog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
+	"github.com/h3poteto/kms-secrets/pkg/decrypter"
+)
+
+// DryRunDecrypt decrypts the KMSSecret in the same way as the reconciliation, and discards the plaintext.
+// It returns the sanitized messages of the keys of encryptedData and the names of encryptedDataFrom which failed permanently,
+// e.g. the controller is not allowed to use the key. Timeouts, throttling and other transient errors are ignored,
+// because the controller retries them.
+// The region is resolved with the namespace annotation and defaultRegion in the same way as the reconciliation,
+// and an error is returned only if no region is found.
+func DryRunDecrypt(ctx context.Context, c client.Reader, d decrypter.Decrypter, kind *secretv1beta1.KMSSecret, defaultRegion string, parallelism int) (map[string]string, error) {
+	region, err := resolveRegion(ctx, c, kind, defaultRegion)
+	if err != nil {
+		if isPermanent(err) {
+			return nil, err
+		}
+		ctrklog.Infof(ctx, "ignore transient error of region in dry-run decryption: %v", err)
+		return nil, nil
+	}
+	_, errs := decryptData(ctx, d, withRegion(kind, region), parallelism)
+	failures := make(map[string]string)
+	for key, err := range errs {
+		if !isPermanent(err) {
+			ctrklog.Infof(ctx, "ignore transient error of %s in dry-run decryption: %v", key, err)
+			continue
+		}
+		failures[key] = errorClass(err) + ": " + sanitizeError(err)
+	}
+	return failures, nil
+}
@@ -19,25 +19,27 @@ package controllers
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/go-logr/logr"
 	"github.com/h3poteto/controller-klog/pkg/ctrklog"
-	"gopkg.in/yaml.v3"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
+	"github.com/h3poteto/kms-secrets/pkg/decrypter"
 )
 
 // KMSSecretReconciler reconciles a KMSSecret object
@@ -46,12 +48,31 @@ type KMSSecretReconciler struct {
 	Log      logr.Logger
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+	// Decrypter decrypts encryptedData of KMSSecret.
+	Decrypter decrypter.Decrypter
+	// DefaultRegion is the region of KMSSecrets which do not specify the region, unless the namespace has the region annotation.
+	DefaultRegion string
+	// DecryptParallelism is the number of keys which are decrypted concurrently in a reconciliation.
+	DecryptParallelism int
+	// DecryptTimeout bounds all decryptions in a reconciliation. If it is zero, decryptions are bound only by the reconciliation.
+	DecryptTimeout time.Duration
+	// PermanentFailureRequeueInterval is the interval to retry decryptions which failed permanently.
+	PermanentFailureRequeueInterval time.Duration
+	// MaxConcurrentReconciles is the number of KMSSecrets which are reconciled concurrently.
+	MaxConcurrentReconciles int
+	// AllowCrossNamespaceTargets allows KMSSecrets to write Secrets into other namespaces with spec.target.namespaces.
+	AllowCrossNamespaceTargets bool
+
+	// backoff is the backoff of throttled decryptions for each object.
+	backoff requeueBackoff
 }
 
 // +kubebuilder:rbac:groups=secret.h3poteto.dev,resources=kmssecrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=secret.h3poteto.dev,resources=kmssecrets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=secret.h3poteto.dev,resources=kmssecrets/finalizers,verbs=update
 // +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
 
 func (r *KMSSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	ctx = ctrklog.SetController(ctx, "kmssecret")
@@ -61,131 +82,409 @@ func (r *KMSSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	kind := secretv1beta1.KMSSecret{}
 	if err := r.Client.Get(ctx, req.NamespacedName, &kind); err != nil {
 		ctrklog.Errorf(ctx, "failed to get KMSSecret: %v", err)
+		if apierrors.IsNotFound(err) {
+			forgetStatusMetrics("KMSSecret", req.Namespace, req.Name)
+		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
 	ctx = ctrklog.SetObject(ctx, kind.Name)
 
-	decryptedData, err := decryptData(ctx, kind.Spec.EncryptedData, kind.Spec.Region)
-	if err != nil {
-		ctrklog.Errorf(ctx, "failed to decrypt data: %v", err)
+	if !kind.DeletionTimestamp.IsZero() {
+		if err := r.finalize(ctx, &kind); err != nil {
+			ctrklog.Errorf(ctx, "failed to finalize KMSSecret %s/%s: %v", kind.Namespace, kind.Name, err)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	if err := r.ensureFinalizer(ctx, &kind); err != nil {
+		ctrklog.Errorf(ctx, "failed to add finalizer to KMSSecret %s/%s: %v", kind.Namespace, kind.Name, err)
+		return ctrl.Result{}, err
+	}
 
+	original := kind.DeepCopy()
+	syncErr := r.syncSecret(ctx, &kind)
+	kind.Status.ObservedGeneration = kind.Generation
+	kind.Status.LastError = sanitizeError(syncErr)
+	recordStatusMetrics("KMSSecret", kind.Namespace, kind.Name, &kind.Status, syncErr == nil)
+	if err := r.updateStatus(ctx, original, &kind); err != nil {
+		ctrklog.Errorf(ctx, "failed to update KMSSecret %s/%s: %v", kind.Namespace, kind.Name, err)
 		return ctrl.Result{}, err
 	}
+	if syncErr != nil {
+		return requeueResult(&r.backoff, req.NamespacedName, syncErr, r.PermanentFailureRequeueInterval)
+	}
+
+	ctrklog.Info(ctx, "resource status synced")
+
+	return requeueResult(&r.backoff, req.NamespacedName, nil, r.PermanentFailureRequeueInterval)
+}
+
+// syncSecret decrypts the KMSSecret and creates or updates the Secret, then records the result in the status of kind.
+func (r *KMSSecretReconciler) syncSecret(ctx context.Context, kind *secretv1beta1.KMSSecret) error {
+	return r.secretDecrypter().decryptAndWrite(ctx, kind, kind, func(decryptedData map[string][]byte, failed map[string]error) error {
+		return r.writeSecrets(ctx, kind, decryptedData, failed)
+	})
+}
+
+// writeSecrets creates or updates the Secrets of all targets with the decrypted data according to the creation policy,
+// and deletes the Secrets which are removed from the targets.
+// failed is the keys which could not be decrypted, and they keep the values of the existing Secret in KeepPrevious policy.
+func (r *KMSSecretReconciler) writeSecrets(ctx context.Context, kind *secretv1beta1.KMSSecret, decryptedData map[string][]byte, failed map[string]error) error {
+	policy := creationPolicy(kind)
+	if policy == secretv1beta1.CreationPolicyNone {
+		ctrklog.Info(ctx, "creationPolicy is None, so skip managing Secret")
+		kind.Status.SecretsSum = shasumData(decryptedData)
+		markSkipped(kind)
+		return nil
+	}
+
+	targets := secretTargets(kind)
+	if err := r.checkTargets(kind, targets); err != nil {
+		ctrklog.Error(ctx, err)
+		markSyncFailed(kind, secretv1beta1.ReasonTargetNotAllowed, err)
+		return err
+	}
+
+	var syncErr error
+	var shasum string
+	written := false
+	for i, target := range targets {
+		sum, w, err := r.secretWriter().writeSecret(ctx, kind, kind, target, policy, decryptedData, failed)
+		if err != nil {
+			if syncErr == nil {
+				syncErr = err
+			}
+			continue
+		}
+		// All targets have the same data except Merge policy, so the sum of the first target is recorded.
+		if i == 0 {
+			shasum = sum
+		}
+		written = written || w
+	}
+
+	// Secrets in other namespaces are owned through the owner annotation, so kind is the owner in every namespace.
+	ownerOf := func(string) *secretv1beta1.KMSSecret { return kind }
+	if err := r.secretWriter().cleanupSecrets(ctx, kind, ownerOf, kind.Status.Secrets, targets, policy); err != nil {
+		// Keep the previous Secrets in the status, so they are deleted in the next reconciliation.
+		kind.Status.Secrets = mergeSecretReferences(kind.Status.Secrets, targets)
+		if syncErr == nil {
+			syncErr = &syncError{reason: secretv1beta1.ReasonSyncFailed, err: err}
+		}
+	} else {
+		kind.Status.Secrets = targets
+	}
 
-	shasum := shasumData(decryptedData)
+	if syncErr != nil {
+		var serr *syncError
+		if errors.As(syncErr, &serr) {
+			markSyncFailed(kind, serr.reason, serr.err)
+			return serr.err
+		}
+		markSyncFailed(kind, secretv1beta1.ReasonSyncFailed, syncErr)
+		return syncErr
+	}
+	kind.Status.SecretsSum = shasum
+	markSynced(kind, written || kind.Status.LastSyncTime == nil)
+	return nil
+}
+
+// secretWriter writes the generated Secrets. It is shared by KMSSecretReconciler and ClusterKMSSecretReconciler.
+type secretWriter struct {
+	client   client.Client
+	recorder record.EventRecorder
+}
+
+// secretDecrypter decrypts the data of KMSSecrets and records the result in the conditions.
+// It is shared by KMSSecretReconciler and ClusterKMSSecretReconciler.
+type secretDecrypter struct {
+	client        client.Reader
+	recorder      record.EventRecorder
+	decrypter     decrypter.Decrypter
+	defaultRegion string
+	parallelism   int
+	timeout       time.Duration
+}
+
+// decryptAndWrite decrypts kind and merges the plaintext data, then passes them to write with the keys which could not be decrypted.
+// write is not called if some keys could not be decrypted in FailAll policy. The decryption error is returned when write succeeds.
+// Events are recorded to owner.
+func (d *secretDecrypter) decryptAndWrite(ctx context.Context, owner runtime.Object, kind *secretv1beta1.KMSSecret, write func(decryptedData map[string][]byte, failed map[string]error) error) error {
+	region, err := resolveRegion(ctx, d.client, kind, d.defaultRegion)
+	if err != nil {
+		ctrklog.Errorf(ctx, "failed to resolve region: %v", err)
+		markDecryptFailed(kind, err)
+		return err
+	}
+	decryptCtx, cancel := decryptContext(ctx, d.timeout)
+	defer cancel()
+	decryptedData, errs := decryptData(decryptCtx, d.decrypter, withRegion(kind, region), d.parallelism)
+	kind.Status.Data = dataStatuses(kind, errs)
+	decryptedData, overridden := mergePlaintextData(kind, decryptedData)
+	if len(overridden) > 0 {
+		ctrklog.Infof(ctx, "keys are specified in more than one of data, stringData and encryptedData: %s", strings.Join(overridden, ", "))
+		d.recorder.Eventf(owner, corev1.EventTypeWarning, "KeyConflict", "Keys %s are specified in more than one of data, stringData and encryptedData, so stringData overrides data and encryptedData overrides both", strings.Join(overridden, ", "))
+	}
+	if len(errs) == 0 {
+		markDecrypted(kind)
+		return write(decryptedData, nil)
+	}
+
+	decryptErr := decryptError(errs, dataCount(kind))
+	ctrklog.Errorf(ctx, "failed to decrypt data: %v", decryptErr)
+	markDecryptFailed(kind, decryptErr)
+	if failurePolicy(kind) == secretv1beta1.FailurePolicyFailAll {
+		return decryptErr
+	}
+	// Write the keys which are decrypted, and report the failed keys in the status.
+	if err := write(decryptedData, errs); err != nil {
+		return err
+	}
+	return decryptErr
+}
 
-	ctrklog.Info(ctx, "checking if an existing Secret for this resource")
+// writeSecret creates or updates the Secret of the target. It returns the sum of the desired data, and whether the Secret is written.
+// Events are recorded to owner, and kind defines the generated Secret.
+// Errors are returned as syncError, so the caller can set the reason of conditions.
+func (w *secretWriter) writeSecret(ctx context.Context, owner client.Object, kind *secretv1beta1.KMSSecret, target corev1.SecretReference, policy secretv1beta1.SecretCreationPolicy, decryptedData map[string][]byte, failed map[string]error) (string, bool, error) {
+	ctrklog.Infof(ctx, "checking if an existing Secret %s/%s for this resource", target.Namespace, target.Name)
 	secret := corev1.Secret{}
-	err = r.Client.Get(ctx, client.ObjectKey{Namespace: kind.Namespace, Name: kind.Name}, &secret)
+	err := w.client.Get(ctx, client.ObjectKey{Namespace: target.Namespace, Name: target.Name}, &secret)
 
 	// Create a new Secret if there is no secret associated with KMSSecret.
 	if apierrors.IsNotFound(err) {
-		ctrklog.Info(ctx, "could not find existing Secret for KMSSecret, creating one...")
+		if policy == secretv1beta1.CreationPolicyMerge {
+			err := fmt.Errorf("secret %s/%s does not exist, so could not merge data into it", target.Namespace, target.Name)
+			ctrklog.Error(ctx, err)
+			return "", false, &syncError{reason: secretv1beta1.ReasonSecretNotFound, err: err}
+		}
+		ctrklog.Infof(ctx, "could not find existing Secret %s/%s for KMSSecret, creating one...", target.Namespace, target.Name)
 
-		secret := buildSecret(kind, decryptedData)
-		if err := r.Client.Create(ctx, secret); err != nil {
+		secret, err := buildSecret(*kind, target, decryptedData)
+		if err != nil {
+			ctrklog.Error(ctx, err)
+			return "", false, &syncError{reason: secretv1beta1.ReasonTemplateFailed, err: err}
+		}
+		if err := validateSecret(secret); err != nil {
+			ctrklog.Error(ctx, err)
+			return "", false, &syncError{reason: secretv1beta1.ReasonInvalidSecretData, err: err}
+		}
+		if err := w.client.Create(ctx, secret); err != nil {
 			ctrklog.Errorf(ctx, "failed to create Secret %s/%s: %v", secret.Namespace, secret.Name, err)
-			return ctrl.Result{}, err
+			return "", false, &syncError{reason: secretv1beta1.ReasonSyncFailed, err: err}
 		}
 
-		r.Recorder.Eventf(&kind, corev1.EventTypeNormal, "Created", "Created Secret %s/%s", secret.Namespace, secret.Name)
+		w.recorder.Eventf(owner, corev1.EventTypeNormal, "Created", "Created Secret %s/%s", secret.Namespace, secret.Name)
+		secretsCreated.WithLabelValues(secret.Namespace).Inc()
 		ctrklog.Infof(ctx, "created Secret %s/%s", secret.Namespace, secret.Name)
+		return shasumData(secret.Data), true, nil
+	}
+	if err != nil {
+		ctrklog.Errorf(ctx, "failed to get Secret %s/%s for KMSSecret %s/%s: %v", target.Namespace, target.Name, kind.Namespace, kind.Name, err)
+		return "", false, &syncError{reason: secretv1beta1.ReasonSyncFailed, err: err}
+	}
 
-		kind.Status.SecretsSum = shasum
-		if err := r.Client.Update(ctx, &kind); err != nil {
-			ctrklog.Errorf(ctx, "failed to update KMSSecret %s/%s: %v", kind.Namespace, kind.Name, err)
-			return ctrl.Result{}, err
-		}
-		ctrklog.Infof(ctx, "updated KMSSecret resource status %s/%s", kind.Namespace, kind.Name)
+	// Never overwrite a Secret which is not managed by this KMSSecret.
+	if err := checkOwnership(&secret, kind, owner.GetNamespace(), policy); err != nil {
+		ctrklog.Error(ctx, err)
+		w.recorder.Event(owner, corev1.EventTypeWarning, "Conflict", err.Error())
+		return "", false, &syncError{reason: secretv1beta1.ReasonConflict, err: err}
+	}
 
-		return ctrl.Result{}, nil
+	data := decryptedData
+	if failurePolicy(kind) == secretv1beta1.FailurePolicyKeepPrevious && len(failed) > 0 {
+		// The previous values differ in each target, so they are not kept in decryptedData.
+		data = make(map[string][]byte, len(decryptedData))
+		for k, v := range decryptedData {
+			data[k] = v
+		}
+		keepPrevious(kind, &secret, data, failed)
 	}
+
+	// Compare the existing Secret with the desired one, and restore it if there are differences.
+	// The Secret may be changed because encryptedData is updated, or because someone edits the Secret out-of-band.
+	desired, err := buildSecret(*kind, target, data)
 	if err != nil {
-		ctrklog.Errorf(ctx, "failed to get Secret for KMSSecret %s/%s: %v", kind.Namespace, kind.Name, err)
-		return ctrl.Result{}, err
+		ctrklog.Error(ctx, err)
+		return "", false, &syncError{reason: secretv1beta1.ReasonTemplateFailed, err: err}
+	}
+	// The sum covers the rendered keys, so changes of templates are reported as updates rather than drift.
+	shasum := shasumData(desired.Data)
+	if policy == secretv1beta1.CreationPolicyMerge {
+		desired = mergeSecret(&secret, desired)
+	}
+	if err := validateSecret(desired); err != nil {
+		ctrklog.Error(ctx, err)
+		return "", false, &syncError{reason: secretv1beta1.ReasonInvalidSecretData, err: err}
+	}
+	if !secretDrifted(&secret, desired) {
+		return shasum, false, nil
 	}
 
-	// Check status and update secret if there are differences.
-	if kind.Status.SecretsSum != shasum {
-		ctrklog.Infof(ctx, "encryptedData is updated, so updating secret resource", "old_secrets_sum", kind.Status.SecretsSum)
-		secret := buildSecret(kind, decryptedData)
-		if err := r.Client.Update(ctx, secret); err != nil {
+	if secret.Type != desired.Type {
+		// Type of Secret is immutable, so the Secret has to be recreated.
+		ctrklog.Infof(ctx, "type of Secret %s/%s is changed, so recreating it", secret.Namespace, secret.Name)
+		if err := w.client.Delete(ctx, &secret); err != nil {
+			ctrklog.Errorf(ctx, "failed to delete Secret %s/%s: %v", secret.Namespace, secret.Name, err)
+			return "", false, &syncError{reason: secretv1beta1.ReasonSyncFailed, err: err}
+		}
+		if err := w.client.Create(ctx, desired); err != nil {
+			ctrklog.Errorf(ctx, "failed to create Secret %s/%s: %v", desired.Namespace, desired.Name, err)
+			return "", false, &syncError{reason: secretv1beta1.ReasonSyncFailed, err: err}
+		}
+	} else {
+		updated := secret.DeepCopy()
+		updated.Labels = desired.Labels
+		updated.Annotations = desired.Annotations
+		updated.OwnerReferences = desired.OwnerReferences
+		updated.Data = desired.Data
+		if err := w.client.Update(ctx, updated); err != nil {
 			ctrklog.Errorf(ctx, "failed to update Secret %s/%s: %v", secret.Namespace, secret.Name, err)
-			return ctrl.Result{}, err
+			return "", false, &syncError{reason: secretv1beta1.ReasonSyncFailed, err: err}
 		}
-		r.Recorder.Eventf(&kind, corev1.EventTypeNormal, "Updated", "Updated Secret %s/%s", secret.Namespace, secret.Name)
-		ctrklog.Info(ctx, "updated Secret %s/%s", secret.Namespace, secret.Name)
+	}
 
-		kind.Status.SecretsSum = shasum
-		if err := r.Client.Update(ctx, &kind); err != nil {
-			ctrklog.Errorf(ctx, "failed to update KMSSecret %s/%s: %v", kind.Namespace, kind.Name, err)
-			return ctrl.Result{}, err
-		}
+	if kind.Status.SecretsSum != shasum {
+		w.recorder.Eventf(owner, corev1.EventTypeNormal, "Updated", "Updated Secret %s/%s", secret.Namespace, secret.Name)
+		secretsUpdated.WithLabelValues(secret.Namespace).Inc()
+		ctrklog.Infof(ctx, "encryptedData is updated, so updated Secret %s/%s, old_secrets_sum: %s", secret.Namespace, secret.Name, kind.Status.SecretsSum)
+	} else {
+		w.recorder.Eventf(owner, corev1.EventTypeNormal, "DriftCorrected", "Restored Secret %s/%s which was modified out-of-band", secret.Namespace, secret.Name)
+		secretsDriftCorrected.WithLabelValues(secret.Namespace).Inc()
+		ctrklog.Infof(ctx, "Secret %s/%s was modified out-of-band, so restored it", secret.Namespace, secret.Name)
 	}
+	return shasum, true, nil
+}
 
-	ctrklog.Info(ctx, "resource status synced")
+func (r *KMSSecretReconciler) secretWriter() *secretWriter {
+	return &secretWriter{client: r.Client, recorder: r.Recorder}
+}
 
-	return ctrl.Result{}, nil
+func (r *KMSSecretReconciler) secretDecrypter() *secretDecrypter {
+	return &secretDecrypter{
+		client:        r.Client,
+		recorder:      r.Recorder,
+		decrypter:     r.Decrypter,
+		defaultRegion: r.DefaultRegion,
+		parallelism:   r.DecryptParallelism,
+		timeout:       r.DecryptTimeout,
+	}
 }
 
-func (r *KMSSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&secretv1beta1.KMSSecret{}).
-		Owns(&corev1.Secret{}).
-		Complete(r)
+// checkTargets returns an error if the targets contain other namespaces and it is not allowed.
+func (r *KMSSecretReconciler) checkTargets(kind *secretv1beta1.KMSSecret, targets []corev1.SecretReference) error {
+	if r.AllowCrossNamespaceTargets {
+		return nil
+	}
+	for _, target := range targets {
+		if target.Namespace != kind.Namespace {
+			return fmt.Errorf("target namespace %s is not allowed, because Secrets can not be written to other namespaces than %s", target.Namespace, kind.Namespace)
+		}
+	}
+	return nil
 }
 
-func buildSecret(kind secretv1beta1.KMSSecret, decryptedData map[string][]byte) *corev1.Secret {
-	secret := corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:            kind.Name,
-			Namespace:       kind.Namespace,
-			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(&kind, secretv1beta1.GroupVersion.WithKind("KMSSecret"))},
-			Labels:          kind.Spec.Template.GetLabels(),
-			Annotations:     kind.Spec.Template.GetAnnotations(),
-		},
-		Data: decryptedData,
-		Type: corev1.SecretTypeOpaque,
-	}
-	return &secret
-}
-
-// decryptData decrypt data using AWS KMS.
-func decryptData(ctx context.Context, encryptedData map[string][]byte, region string) (map[string][]byte, error) {
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
-	svc := kms.New(sess, aws.NewConfig().WithRegion(region))
-	decryptedData := make(map[string][]byte)
-	for key, value := range encryptedData {
-		input := &kms.DecryptInput{
-			CiphertextBlob: value,
-		}
-		decrypted, err := svc.Decrypt(input)
-		if err != nil {
-			ctrklog.Errorf(ctx, "failed to decrypt: %v", err)
-			return nil, err
+// cleanupSecrets deletes the Secrets which were written previously but are removed from the targets. Events are recorded to owner.
+// Only Secrets which are owned by the KMSSecret which ownerOf returns for the namespace are deleted in Owner policy, and other Secrets are left as they are.
+func (w *secretWriter) cleanupSecrets(ctx context.Context, owner runtime.Object, ownerOf func(namespace string) *secretv1beta1.KMSSecret, previous, targets []corev1.SecretReference, policy secretv1beta1.SecretCreationPolicy) error {
+	current := make(map[corev1.SecretReference]bool, len(targets))
+	for _, target := range targets {
+		current[target] = true
+	}
+	for _, ref := range previous {
+		if current[ref] {
+			continue
 		}
-		plain := decrypted.Plaintext
-		value, err = yamlParse(plain)
-		if err != nil {
-			ctrklog.Warningf(ctx, "failed to yaml parse for %s, so insert plain text", key)
-			decryptedData[key] = plain
+		if policy != secretv1beta1.CreationPolicyOwner {
+			ctrklog.Infof(ctx, "Secret %s/%s is removed from the targets, but it is left because creationPolicy is %s", ref.Namespace, ref.Name, policy)
 			continue
 		}
-		decryptedData[key] = value
+		if err := w.deleteOwnedSecret(ctx, owner, ownerOf(ref.Namespace), ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteOwnedSecret deletes the Secret if it is owned by the KMSSecret. Events are recorded to owner.
+func (w *secretWriter) deleteOwnedSecret(ctx context.Context, owner runtime.Object, kind *secretv1beta1.KMSSecret, ref corev1.SecretReference) error {
+	secret := corev1.Secret{}
+	if err := w.client.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, &secret); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !ownedSecret(&secret, kind) {
+		ctrklog.Infof(ctx, "Secret %s/%s is not owned by KMSSecret, so it is left", secret.Namespace, secret.Name)
+		return nil
+	}
+	if err := w.client.Delete(ctx, &secret); err != nil && !apierrors.IsNotFound(err) {
+		ctrklog.Errorf(ctx, "failed to delete Secret %s/%s: %v", secret.Namespace, secret.Name, err)
+		return err
+	}
+	w.recorder.Eventf(owner, corev1.EventTypeNormal, "Deleted", "Deleted Secret %s/%s", secret.Namespace, secret.Name)
+	ctrklog.Infof(ctx, "deleted Secret %s/%s", secret.Namespace, secret.Name)
+	return nil
+}
 
+// finalize deletes the Secrets in other namespaces, which are not deleted by the garbage collector, and removes the finalizer.
+func (r *KMSSecretReconciler) finalize(ctx context.Context, kind *secretv1beta1.KMSSecret) error {
+	if !controllerutil.ContainsFinalizer(kind, secretv1beta1.Finalizer) {
+		return nil
 	}
-	return decryptedData, nil
+	if creationPolicy(kind) == secretv1beta1.CreationPolicyOwner {
+		for _, ref := range kind.Status.Secrets {
+			if ref.Namespace == kind.Namespace {
+				continue
+			}
+			if err := r.secretWriter().deleteOwnedSecret(ctx, kind, kind, ref); err != nil {
+				return err
+			}
+		}
+	}
+	original := kind.DeepCopy()
+	controllerutil.RemoveFinalizer(kind, secretv1beta1.Finalizer)
+	return r.Client.Patch(ctx, kind, client.MergeFrom(original))
 }
 
-func yamlParse(input []byte) ([]byte, error) {
-	var res string
-	if err := yaml.Unmarshal(input, &res); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal: %w", err)
+// ensureFinalizer adds the finalizer if the KMSSecret owns Secrets in other namespaces.
+func (r *KMSSecretReconciler) ensureFinalizer(ctx context.Context, kind *secretv1beta1.KMSSecret) error {
+	if creationPolicy(kind) != secretv1beta1.CreationPolicyOwner || controllerutil.ContainsFinalizer(kind, secretv1beta1.Finalizer) {
+		return nil
 	}
-	return []byte(res), nil
+	for _, target := range secretTargets(kind) {
+		if target.Namespace != kind.Namespace {
+			original := kind.DeepCopy()
+			controllerutil.AddFinalizer(kind, secretv1beta1.Finalizer)
+			return r.Client.Patch(ctx, kind, client.MergeFrom(original))
+		}
+	}
+	return nil
+}
+
+// updateStatus writes the status of kind through the status subresource if it is changed from original.
+// The spec is never written, so it does not conflict with other tools which apply the KMSSecret.
+func (r *KMSSecretReconciler) updateStatus(ctx context.Context, original, kind *secretv1beta1.KMSSecret) error {
+	if !statusChanged(original.Status, kind.Status) {
+		return nil
+	}
+	err := writeStatus(ctx, r.Client, kind, func(latest client.Object) {
+		latest.(*secretv1beta1.KMSSecret).Status = kind.Status
+	})
+	if err != nil {
+		return err
+	}
+	ctrklog.Infof(ctx, "updated KMSSecret resource status %s/%s", kind.Namespace, kind.Name)
+	return nil
+}
+
+func (r *KMSSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&secretv1beta1.KMSSecret{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Owns(&corev1.Secret{}).
+		// Secrets in other namespaces do not have owner references, so they are mapped with the owner annotation.
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(ownerAnnotationRequests)).
+		Complete(r)
 }
 
 func shasumData(data map[string][]byte) string {
@@ -0,0 +1,72 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
+)
+
+var (
+	secretReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kms_secrets_ready",
+		Help: "Whether the generated Secret of the KMSSecret or the ClusterKMSSecret is up to date (1) or not (0)",
+	}, []string{"kind", "namespace", "name"})
+	secretLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kms_secrets_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful reconciliation of the KMSSecret or the ClusterKMSSecret",
+	}, []string{"kind", "namespace", "name"})
+	secretsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kms_secrets_secrets_created_total",
+		Help: "Number of generated Secrets which are created",
+	}, []string{"namespace"})
+	secretsUpdated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kms_secrets_secrets_updated_total",
+		Help: "Number of generated Secrets which are updated because the encrypted data are changed",
+	}, []string{"namespace"})
+	secretsDriftCorrected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kms_secrets_secrets_drift_corrected_total",
+		Help: "Number of generated Secrets which are restored after they were modified out-of-band",
+	}, []string{"namespace"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(secretReady, secretLastSuccess, secretsCreated, secretsUpdated, secretsDriftCorrected)
+}
+
+// recordStatusMetrics records the Ready condition of the object, and the time of the reconciliation if it succeeded.
+func recordStatusMetrics(kind, namespace, name string, status *secretv1beta1.KMSSecretStatus, succeeded bool) {
+	ready := 0.0
+	if meta.IsStatusConditionTrue(status.Conditions, secretv1beta1.ConditionReady) {
+		ready = 1
+	}
+	secretReady.WithLabelValues(kind, namespace, name).Set(ready)
+	if succeeded {
+		secretLastSuccess.WithLabelValues(kind, namespace, name).Set(float64(time.Now().Unix()))
+	}
+}
+
+// forgetStatusMetrics deletes the metrics of the object which does not exist anymore.
+func forgetStatusMetrics(kind, namespace, name string) {
+	secretReady.DeleteLabelValues(kind, namespace, name)
+	secretLastSuccess.DeleteLabelValues(kind, namespace, name)
+}
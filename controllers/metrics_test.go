@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
+	"github.com/h3poteto/kms-secrets/pkg/decrypter"
+)
+
+func TestStatusMetrics(t *testing.T) {
+	d := decrypter.NewFakeDecrypter()
+	d.Add([]byte("encrypted-hoge"), []byte("hoge"))
+	kind := newTestKMSSecret(map[string][]byte{
+		"API_KEY": []byte("encrypted-hoge"),
+	})
+	kind.Name = "metrics"
+	r := newTestReconciler(t, d, kind)
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(kind)}
+	created := testutil.ToFloat64(secretsCreated.WithLabelValues("default"))
+
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if v := testutil.ToFloat64(secretReady.WithLabelValues("KMSSecret", "default", "metrics")); v != 1 {
+		t.Errorf("ready gauge should be 1, but %v", v)
+	}
+	if v := testutil.ToFloat64(secretLastSuccess.WithLabelValues("KMSSecret", "default", "metrics")); v == 0 {
+		t.Errorf("last success timestamp is not set")
+	}
+	if v := testutil.ToFloat64(secretsCreated.WithLabelValues("default")); v != created+1 {
+		t.Errorf("created counter should be incremented, expected: %v, returned: %v", created+1, v)
+	}
+
+	// Metrics of deleted KMSSecrets are removed.
+	res := secretv1beta1.KMSSecret{}
+	if err := r.Client.Get(ctx, req.NamespacedName, &res); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Client.Delete(ctx, &res); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if secretReady.DeleteLabelValues("KMSSecret", "default", "metrics") {
+		t.Errorf("ready gauge of deleted KMSSecret should be removed")
+	}
+}
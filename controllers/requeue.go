@@ -0,0 +1,129 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/h3poteto/kms-secrets/pkg/decrypter"
+)
+
+const (
+	// defaultPermanentFailureRequeueInterval is the interval to retry permanent failures when it is not specified.
+	defaultPermanentFailureRequeueInterval = 10 * time.Minute
+	// throttleBaseDelay is the first delay to retry throttled decryptions.
+	throttleBaseDelay = time.Second
+	// throttleMaxDelay is the maximum delay to retry throttled decryptions.
+	throttleMaxDelay = 5 * time.Minute
+)
+
+// permanentErrorCodes are the AWS error codes which never succeed by retrying the same request.
+var permanentErrorCodes = map[string]bool{
+	kms.ErrCodeInvalidCiphertextException: true,
+	kms.ErrCodeDisabledException:          true,
+	kms.ErrCodeInvalidStateException:      true,
+	kms.ErrCodeNotFoundException:          true,
+	kms.ErrCodeIncorrectKeyException:      true,
+	kms.ErrCodeInvalidKeyUsageException:   true,
+	"AccessDeniedException":               true,
+}
+
+// throttledErrorCodes are the AWS error codes which are retried with the exponential backoff.
+// LimitExceededException is not included, because it is caused by resource quotas of KMS, e.g. the number of grants, rather than the request rate.
+var throttledErrorCodes = map[string]bool{
+	"ThrottlingException":        true,
+	kms.ErrCodeInternalException: true,
+}
+
+// isPermanent returns true if err can not be resolved without changing the KMSSecret, the key or the IAM policy.
+func isPermanent(err error) bool {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		return permanentErrorCodes[aerr.Code()]
+	}
+	return errors.Is(err, decrypter.ErrKeyMismatch) ||
+		errors.Is(err, errReservedEncryptionContext) ||
+		errors.Is(err, errDecode) ||
+		errors.Is(err, errInvalidKey) ||
+		errors.Is(err, errKeyConflict) ||
+		errors.Is(err, errMissingRegion)
+}
+
+// isThrottled returns true if err is caused by the request rate or a temporary failure of KMS.
+func isThrottled(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && throttledErrorCodes[aerr.Code()]
+}
+
+// requeueBackoff computes jittered exponential delays for each object. The zero value is ready to use.
+type requeueBackoff struct {
+	mu       sync.Mutex
+	attempts map[types.NamespacedName]int
+}
+
+// next returns the delay of the next attempt for key, which is doubled for each attempt up to throttleMaxDelay.
+// The delay is jittered between the half and the full, so throttled objects are not retried at the same time.
+func (b *requeueBackoff) next(key types.NamespacedName) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.attempts == nil {
+		b.attempts = make(map[types.NamespacedName]int)
+	}
+	attempt := b.attempts[key]
+	b.attempts[key] = attempt + 1
+	delay := throttleMaxDelay
+	if attempt < 16 && throttleBaseDelay<<attempt < throttleMaxDelay {
+		delay = throttleBaseDelay << attempt
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// reset forgets the attempts of key.
+func (b *requeueBackoff) reset(key types.NamespacedName) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.attempts, key)
+}
+
+// requeueResult returns the result of a reconciliation which failed with err.
+// Permanent failures are retried after interval without the error, so they do not consume the backoff of the controller.
+// Throttled decryptions are retried with the jittered exponential backoff, and other errors are retried by the controller.
+func requeueResult(b *requeueBackoff, key types.NamespacedName, err error, interval time.Duration) (ctrl.Result, error) {
+	switch {
+	case err == nil:
+		b.reset(key)
+		return ctrl.Result{}, nil
+	case isThrottled(err):
+		return ctrl.Result{RequeueAfter: b.next(key)}, nil
+	case isPermanent(err):
+		b.reset(key)
+		if interval <= 0 {
+			interval = defaultPermanentFailureRequeueInterval
+		}
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+	b.reset(key)
+	return ctrl.Result{}, err
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
+	"github.com/h3poteto/kms-secrets/pkg/decrypter"
+)
+
+// errorDecrypter fails every decryption with the error.
+type errorDecrypter struct {
+	err error
+}
+
+func (e errorDecrypter) Decrypt(ctx context.Context, input *decrypter.Input) (*decrypter.Output, error) {
+	return nil, e.err
+}
+
+func TestRequeueResult(t *testing.T) {
+	key := types.NamespacedName{Namespace: "default", Name: "test"}
+	cases := []struct {
+		title        string
+		err          error
+		requeueAfter bool
+		returnError  bool
+	}{
+		{
+			title: "succeeded",
+		},
+		{
+			title:        "throttled",
+			err:          fmt.Errorf("failed to decrypt: %w", awserr.New("ThrottlingException", "rate exceeded", nil)),
+			requeueAfter: true,
+		},
+		{
+			title:        "permanent",
+			err:          fmt.Errorf("failed to decrypt: %w", awserr.New("DisabledException", "key is disabled", nil)),
+			requeueAfter: true,
+		},
+		{
+			title:        "incorrect key",
+			err:          fmt.Errorf("failed to decrypt: %w", awserr.New("IncorrectKeyException", "key ID does not match", nil)),
+			requeueAfter: true,
+		},
+		{
+			title:        "invalid key usage",
+			err:          fmt.Errorf("failed to decrypt: %w", awserr.New("InvalidKeyUsageException", "key usage is SIGN_VERIFY", nil)),
+			requeueAfter: true,
+		},
+		{
+			title:        "key mismatch",
+			err:          fmt.Errorf("failed to decrypt: %w", decrypter.ErrKeyMismatch),
+			requeueAfter: true,
+		},
+		{
+			title:       "limit exceeded",
+			err:         fmt.Errorf("failed to decrypt: %w", awserr.New("LimitExceededException", "too many grants", nil)),
+			returnError: true,
+		},
+		{
+			title:       "unknown",
+			err:         errors.New("connection refused"),
+			returnError: true,
+		},
+	}
+	for _, c := range cases {
+		b := &requeueBackoff{}
+		res, err := requeueResult(b, key, c.err, time.Hour)
+		if c.returnError != (err != nil) {
+			t.Errorf("%s: unexpected error: %v", c.title, err)
+		}
+		if c.requeueAfter != (res.RequeueAfter > 0) {
+			t.Errorf("%s: unexpected result: %#v", c.title, res)
+		}
+	}
+}
+
+func TestRequeueBackoff(t *testing.T) {
+	key := types.NamespacedName{Namespace: "default", Name: "test"}
+	b := &requeueBackoff{}
+	for i := 0; i < 20; i++ {
+		expected := throttleMaxDelay
+		if i < 16 && throttleBaseDelay<<i < throttleMaxDelay {
+			expected = throttleBaseDelay << i
+		}
+		delay := b.next(key)
+		if delay < expected/2 || delay > expected {
+			t.Errorf("attempt %d: delay should be between %s and %s, but %s", i, expected/2, expected, delay)
+		}
+	}
+	b.reset(key)
+	if delay := b.next(key); delay > throttleBaseDelay {
+		t.Errorf("delay should be reset, but %s", delay)
+	}
+}
+
+func TestDecryptErrorCause(t *testing.T) {
+	permanent := awserr.New("AccessDeniedException", "access denied", nil)
+	throttled := awserr.New("ThrottlingException", "rate exceeded", nil)
+	err := decryptError(map[string]error{"A": permanent, "B": throttled}, 2)
+	if isPermanent(err) || !isThrottled(err) {
+		t.Errorf("error should be throttled if some keys are throttled: %v", err)
+	}
+	err = decryptError(map[string]error{"A": permanent, "B": permanent}, 2)
+	if !isPermanent(err) {
+		t.Errorf("error should be permanent if all keys failed permanently: %v", err)
+	}
+}
+
+func TestReconcileStalled(t *testing.T) {
+	kind := newTestKMSSecret(map[string][]byte{
+		"API_KEY": []byte("encrypted-hoge"),
+	})
+	d := errorDecrypter{err: awserr.New("DisabledException", "key is disabled", nil)}
+	r := newTestReconciler(t, d, kind)
+	r.PermanentFailureRequeueInterval = time.Hour
+	ctx := context.Background()
+
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(kind)}
+	res, err := r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("permanent failure should not return an error: %v", err)
+	}
+	if res.RequeueAfter != time.Hour {
+		t.Errorf("permanent failure should be requeued after the interval: %#v", res)
+	}
+
+	got := secretv1beta1.KMSSecret{}
+	if err := r.Client.Get(ctx, req.NamespacedName, &got); err != nil {
+		t.Fatal(err)
+	}
+	cond := meta.FindStatusCondition(got.Status.Conditions, secretv1beta1.ConditionStalled)
+	if cond == nil || cond.Status != metav1.ConditionTrue || cond.Reason != "DisabledException" {
+		t.Errorf("Stalled condition is not matched: %#v", cond)
+	}
+
+	// Stalled condition is removed once the key is enabled again.
+	fake := decrypter.NewFakeDecrypter()
+	fake.Add([]byte("encrypted-hoge"), []byte("hoge"))
+	r.Decrypter = fake
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Client.Get(ctx, req.NamespacedName, &got); err != nil {
+		t.Fatal(err)
+	}
+	if meta.FindStatusCondition(got.Status.Conditions, secretv1beta1.ConditionStalled) != nil {
+		t.Errorf("Stalled condition should be removed: %#v", got.Status.Conditions)
+	}
+}
@@ -0,0 +1,350 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
+)
+
+func creationPolicy(kind *secretv1beta1.KMSSecret) secretv1beta1.SecretCreationPolicy {
+	if kind.Spec.Target.CreationPolicy == "" {
+		return secretv1beta1.CreationPolicyOwner
+	}
+	return kind.Spec.Target.CreationPolicy
+}
+
+func secretType(kind *secretv1beta1.KMSSecret) corev1.SecretType {
+	if kind.Spec.Template.Type == "" {
+		return corev1.SecretTypeOpaque
+	}
+	return kind.Spec.Template.Type
+}
+
+// validateSecret returns an error if the data of Secret do not have the required keys of the Secret type.
+// The rules are the same as the validation of kube-apiserver, so the error is reported before the Secret is written.
+func validateSecret(secret *corev1.Secret) error {
+	requireKeys := func(keys ...string) error {
+		for _, key := range keys {
+			if _, ok := secret.Data[key]; !ok {
+				return fmt.Errorf("%s Secret requires %s in data", secret.Type, key)
+			}
+		}
+		return nil
+	}
+	requireJSON := func(key string) error {
+		if err := requireKeys(key); err != nil {
+			return err
+		}
+		if !json.Valid(secret.Data[key]) {
+			return fmt.Errorf("%s in %s Secret is not valid JSON", key, secret.Type)
+		}
+		return nil
+	}
+
+	switch secret.Type {
+	case corev1.SecretTypeTLS:
+		return requireKeys(corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+	case corev1.SecretTypeDockerConfigJson:
+		return requireJSON(corev1.DockerConfigJsonKey)
+	case corev1.SecretTypeDockercfg:
+		return requireJSON(corev1.DockerConfigKey)
+	case corev1.SecretTypeBasicAuth:
+		_, username := secret.Data[corev1.BasicAuthUsernameKey]
+		_, password := secret.Data[corev1.BasicAuthPasswordKey]
+		if !username && !password {
+			return fmt.Errorf("%s Secret requires %s or %s in data", secret.Type, corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey)
+		}
+		return nil
+	case corev1.SecretTypeSSHAuth:
+		return requireKeys(corev1.SSHAuthPrivateKey)
+	case corev1.SecretTypeServiceAccountToken:
+		return fmt.Errorf("%s Secret is not supported", secret.Type)
+	}
+	return nil
+}
+
+// checkOwnership returns an error if the existing Secret must not be written by the KMSSecret.
+// The Secret is writable when it is owned by the KMSSecret, or when it has no controller and it has the managed annotation.
+// Merge policy writes the existing Secret in ownerNamespace, which is the namespace of the KMSSecret, because it is explicitly requested.
+// Secrets in other namespaces are merged only with the managed annotation, otherwise a KMSSecret could overwrite any Secret there.
+// ownerNamespace is empty for ClusterKMSSecret, so it merges only Secrets with the managed annotation.
+func checkOwnership(secret *corev1.Secret, kind *secretv1beta1.KMSSecret, ownerNamespace string, policy secretv1beta1.SecretCreationPolicy) error {
+	if ownedSecret(secret, kind) {
+		return nil
+	}
+	if policy == secretv1beta1.CreationPolicyMerge && ownerNamespace != "" && secret.Namespace == ownerNamespace {
+		return nil
+	}
+	if ref := metav1.GetControllerOf(secret); ref != nil {
+		return fmt.Errorf("secret %s/%s is already controlled by %s %s", secret.Namespace, secret.Name, ref.Kind, ref.Name)
+	}
+	if secret.Annotations[secretv1beta1.ManagedAnnotation] != "true" {
+		return fmt.Errorf("secret %s/%s already exists and is not managed by KMSSecret, please add %s: \"true\" annotation to adopt it", secret.Namespace, secret.Name, secretv1beta1.ManagedAnnotation)
+	}
+	return nil
+}
+
+// ownedSecret returns true if the Secret is controlled by the KMSSecret, or if it has the owner annotation of the KMSSecret.
+func ownedSecret(secret *corev1.Secret, kind *secretv1beta1.KMSSecret) bool {
+	if metav1.IsControlledBy(secret, kind) {
+		return true
+	}
+	return metav1.GetControllerOf(secret) == nil && secret.Annotations[secretv1beta1.OwnerAnnotation] == ownerKey(kind)
+}
+
+// ownerKey returns the value of the owner annotation. ClusterKMSSecret is recorded only with the name,
+// so it is never confused with a KMSSecret which has the same name in the namespace of the Secret.
+func ownerKey(kind *secretv1beta1.KMSSecret) string {
+	if kind.Kind == "ClusterKMSSecret" {
+		return kind.Name
+	}
+	return kind.Namespace + "/" + kind.Name
+}
+
+// ownerAnnotationRequests maps a Secret to the KMSSecret which is recorded in the owner annotation.
+func ownerAnnotationRequests(obj client.Object) []reconcile.Request {
+	owner, ok := obj.GetAnnotations()[secretv1beta1.OwnerAnnotation]
+	if !ok {
+		return nil
+	}
+	namespace, name, ok := strings.Cut(owner, "/")
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}}
+}
+
+// secretTargets returns the Secrets which are generated by the KMSSecret, which are sorted by the namespace.
+// The Secret in the namespace of KMSSecret is always the first target.
+func secretTargets(kind *secretv1beta1.KMSSecret) []corev1.SecretReference {
+	name := kind.Spec.Target.Name
+	if name == "" {
+		name = kind.Name
+	}
+	targets := []corev1.SecretReference{{Namespace: kind.Namespace, Name: name}}
+	namespaces := make([]string, 0, len(kind.Spec.Target.Namespaces))
+	seen := map[string]bool{kind.Namespace: true}
+	for _, ns := range kind.Spec.Target.Namespaces {
+		if seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		targets = append(targets, corev1.SecretReference{Namespace: ns, Name: name})
+	}
+	return targets
+}
+
+// ownerReference returns the controller reference to the KMSSecret.
+// The kind of TypeMeta is used if it is set, so ClusterKMSSecret can be the owner through the KMSSecret which is converted from it.
+func ownerReference(kind *secretv1beta1.KMSSecret) metav1.OwnerReference {
+	gvk := secretv1beta1.GroupVersion.WithKind("KMSSecret")
+	if kind.Kind != "" {
+		gvk = kind.GroupVersionKind()
+	}
+	return *metav1.NewControllerRef(kind, gvk)
+}
+
+// mergeSecretReferences returns the union of a and b, which is sorted by the namespace and the name.
+func mergeSecretReferences(a, b []corev1.SecretReference) []corev1.SecretReference {
+	seen := make(map[corev1.SecretReference]bool, len(a)+len(b))
+	res := make([]corev1.SecretReference, 0, len(a)+len(b))
+	for _, refs := range [][]corev1.SecretReference{a, b} {
+		for _, ref := range refs {
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			res = append(res, ref)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Namespace != res[j].Namespace {
+			return res[i].Namespace < res[j].Namespace
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// secretDrifted returns true if data, labels, annotations, type or the controller of the live Secret differ from the desired Secret.
+func secretDrifted(live, desired *corev1.Secret) bool {
+	return live.Type != desired.Type ||
+		!equality.Semantic.DeepEqual(live.Data, desired.Data) ||
+		!equality.Semantic.DeepEqual(live.Labels, desired.Labels) ||
+		!equality.Semantic.DeepEqual(live.Annotations, desired.Annotations) ||
+		!equality.Semantic.DeepEqual(metav1.GetControllerOf(live), metav1.GetControllerOf(desired))
+}
+
+// mergePlaintextData returns data and stringData of the KMSSecret with the decrypted data merged over them, and the sorted keys which are overridden.
+// The same key in them is rejected by the CRD validation and the webhook, but stringData overrides data and the decrypted data override both when they are not enabled.
+func mergePlaintextData(kind *secretv1beta1.KMSSecret, decryptedData map[string][]byte) (map[string][]byte, []string) {
+	if len(kind.Spec.Data) == 0 && len(kind.Spec.StringData) == 0 {
+		return decryptedData, nil
+	}
+	res := make(map[string][]byte, len(kind.Spec.Data)+len(kind.Spec.StringData)+len(decryptedData))
+	overridden := map[string]bool{}
+	for k, v := range kind.Spec.Data {
+		res[k] = v
+	}
+	for k, v := range kind.Spec.StringData {
+		if _, ok := res[k]; ok {
+			overridden[k] = true
+		}
+		res[k] = []byte(v)
+	}
+	for k, v := range decryptedData {
+		if _, ok := res[k]; ok {
+			overridden[k] = true
+		}
+		res[k] = v
+	}
+	keys := make([]string, 0, len(overridden))
+	for k := range overridden {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return res, keys
+}
+
+// buildSecret returns the desired Secret of the KMSSecret.
+// The templates of spec.template.data are rendered with the decrypted data, and the rendered keys override the decrypted keys.
+// Secrets in other namespaces than the KMSSecret have the owner annotation instead of the owner reference.
+func buildSecret(kind secretv1beta1.KMSSecret, target corev1.SecretReference, decryptedData map[string][]byte) (*corev1.Secret, error) {
+	data := decryptedData
+	if len(kind.Spec.Template.Data) > 0 {
+		rendered, err := renderTemplates(kind.Spec.Template.Data, decryptedData)
+		if err != nil {
+			return nil, err
+		}
+		data = make(map[string][]byte, len(decryptedData)+len(rendered))
+		for k, v := range decryptedData {
+			data[k] = v
+		}
+		for k, v := range rendered {
+			data[k] = v
+		}
+	}
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        target.Name,
+			Namespace:   target.Namespace,
+			Labels:      kind.Spec.Template.GetLabels(),
+			Annotations: kind.Spec.Template.GetAnnotations(),
+		},
+		Data: data,
+		Type: secretType(&kind),
+	}
+	annotations := make(map[string]string, len(secret.Annotations)+2)
+	for k, v := range secret.Annotations {
+		annotations[k] = v
+	}
+	crossNamespace := target.Namespace != kind.Namespace
+	switch creationPolicy(&kind) {
+	case secretv1beta1.CreationPolicyOwner:
+		if !crossNamespace {
+			secret.OwnerReferences = []metav1.OwnerReference{ownerReference(&kind)}
+		}
+	case secretv1beta1.CreationPolicyOrphan:
+		// The Secret does not have owner references, so the owner is recorded to manage it in the next reconciliation.
+		// The managed annotation is not set, otherwise other KMSSecrets could adopt the Secret after this KMSSecret is deleted.
+		annotations[secretv1beta1.OwnerAnnotation] = ownerKey(&kind)
+	}
+	if crossNamespace {
+		// Owner references can not refer to objects in other namespaces, so the Secret is tracked with the annotation and deleted by the finalizer.
+		annotations[secretv1beta1.OwnerAnnotation] = ownerKey(&kind)
+	}
+	if len(annotations) > 0 {
+		secret.Annotations = annotations
+	}
+	return &secret, nil
+}
+
+// mergeSecret returns a copy of the live Secret with the desired data, labels and annotations merged into it.
+// Type and owner references of the live Secret are kept.
+func mergeSecret(live, desired *corev1.Secret) *corev1.Secret {
+	merged := live.DeepCopy()
+	if merged.Data == nil {
+		merged.Data = make(map[string][]byte, len(desired.Data))
+	}
+	for k, v := range desired.Data {
+		merged.Data[k] = v
+	}
+	if len(desired.Labels) > 0 && merged.Labels == nil {
+		merged.Labels = make(map[string]string, len(desired.Labels))
+	}
+	for k, v := range desired.Labels {
+		merged.Labels[k] = v
+	}
+	if len(desired.Annotations) > 0 && merged.Annotations == nil {
+		merged.Annotations = make(map[string]string, len(desired.Annotations))
+	}
+	for k, v := range desired.Annotations {
+		merged.Annotations[k] = v
+	}
+	return merged
+}
+
+// keepPrevious copies the values of the failed keys from the existing Secret into decryptedData.
+// Keys which are expanded from a failed document are unknown, so the keys of the existing Secret which match the document are kept.
+func keepPrevious(kind *secretv1beta1.KMSSecret, existing *corev1.Secret, decryptedData map[string][]byte, failed map[string]error) {
+	for key := range failed {
+		if value, ok := existing.Data[key]; ok {
+			decryptedData[key] = value
+		}
+	}
+	for _, entry := range kind.Spec.EncryptedDataFrom {
+		if _, ok := failed[entry.Name]; !ok {
+			continue
+		}
+		if len(entry.Keys) > 0 {
+			for _, k := range entry.Keys {
+				if value, ok := existing.Data[entry.Prefix+k]; ok {
+					decryptedData[entry.Prefix+k] = value
+				}
+			}
+			continue
+		}
+		for key, value := range existing.Data {
+			if !strings.HasPrefix(key, entry.Prefix) {
+				continue
+			}
+			if _, ok := kind.Spec.EncryptedData[key]; ok {
+				continue
+			}
+			if _, ok := decryptedData[key]; ok {
+				continue
+			}
+			decryptedData[key] = value
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestValidateSecret(t *testing.T) {
+	cases := []struct {
+		title       string
+		secretType  corev1.SecretType
+		data        map[string]string
+		expectError bool
+	}{
+		{
+			title:      "Opaque accepts any keys",
+			secretType: corev1.SecretTypeOpaque,
+			data:       map[string]string{"API_KEY": "hoge"},
+		},
+		{
+			title:      "TLS has a certificate and a key",
+			secretType: corev1.SecretTypeTLS,
+			data:       map[string]string{"tls.crt": "cert", "tls.key": "key"},
+		},
+		{
+			title:       "TLS does not have a key",
+			secretType:  corev1.SecretTypeTLS,
+			data:        map[string]string{"tls.crt": "cert"},
+			expectError: true,
+		},
+		{
+			title:      "dockerconfigjson is valid JSON",
+			secretType: corev1.SecretTypeDockerConfigJson,
+			data:       map[string]string{".dockerconfigjson": `{"auths":{}}`},
+		},
+		{
+			title:       "dockerconfigjson is not valid JSON",
+			secretType:  corev1.SecretTypeDockerConfigJson,
+			data:        map[string]string{".dockerconfigjson": "auths"},
+			expectError: true,
+		},
+		{
+			title:      "basic-auth has only a password",
+			secretType: corev1.SecretTypeBasicAuth,
+			data:       map[string]string{"password": "hoge"},
+		},
+		{
+			title:       "basic-auth has neither a username nor a password",
+			secretType:  corev1.SecretTypeBasicAuth,
+			data:        map[string]string{"token": "hoge"},
+			expectError: true,
+		},
+		{
+			title:       "ssh-auth does not have a private key",
+			secretType:  corev1.SecretTypeSSHAuth,
+			data:        map[string]string{},
+			expectError: true,
+		},
+	}
+
+	for _, c := range cases {
+		secret := &corev1.Secret{
+			Type: c.secretType,
+			Data: make(map[string][]byte, len(c.data)),
+		}
+		for k, v := range c.data {
+			secret.Data[k] = []byte(v)
+		}
+		err := validateSecret(secret)
+		if c.expectError != (err != nil) {
+			t.Errorf("%s: unexpected result: %v", c.title, err)
+		}
+	}
+}
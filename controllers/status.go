@@ -0,0 +1,204 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
+	"github.com/h3poteto/kms-secrets/pkg/decrypter"
+)
+
+// maxErrorLength is the maximum length of error messages which are recorded in the status.
+const maxErrorLength = 256
+
+func setCondition(kind *secretv1beta1.KMSSecret, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&kind.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: kind.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+func markDecrypted(kind *secretv1beta1.KMSSecret) {
+	setCondition(kind, secretv1beta1.ConditionDecrypted, metav1.ConditionTrue, secretv1beta1.ReasonSucceeded, "All encrypted data are decrypted")
+	meta.RemoveStatusCondition(&kind.Status.Conditions, secretv1beta1.ConditionStalled)
+}
+
+func markDecryptFailed(kind *secretv1beta1.KMSSecret, err error) {
+	message := sanitizeError(err)
+	reason := secretv1beta1.ReasonDecryptFailed
+	if isTimeout(err) {
+		reason = secretv1beta1.ReasonTimeout
+	}
+	setCondition(kind, secretv1beta1.ConditionDecrypted, metav1.ConditionFalse, reason, message)
+	setCondition(kind, secretv1beta1.ConditionSecretSynced, metav1.ConditionUnknown, reason, "Secret is not synced because decryption failed")
+	setCondition(kind, secretv1beta1.ConditionReady, metav1.ConditionFalse, reason, message)
+	if isPermanent(err) {
+		setCondition(kind, secretv1beta1.ConditionStalled, metav1.ConditionTrue, errorClass(err), message)
+	} else {
+		meta.RemoveStatusCondition(&kind.Status.Conditions, secretv1beta1.ConditionStalled)
+	}
+}
+
+// markSynced sets conditions for the synced Secret. If written is true, LastSyncTime is updated.
+// Ready condition is kept false when some keys could not be decrypted.
+func markSynced(kind *secretv1beta1.KMSSecret, written bool) {
+	if written {
+		now := metav1.Now()
+		kind.Status.LastSyncTime = &now
+	}
+	setCondition(kind, secretv1beta1.ConditionSecretSynced, metav1.ConditionTrue, secretv1beta1.ReasonSucceeded, "Secret is synced")
+	if meta.IsStatusConditionFalse(kind.Status.Conditions, secretv1beta1.ConditionDecrypted) {
+		return
+	}
+	setCondition(kind, secretv1beta1.ConditionReady, metav1.ConditionTrue, secretv1beta1.ReasonSucceeded, "Secret is up to date")
+}
+
+func markSyncFailed(kind *secretv1beta1.KMSSecret, reason string, err error) {
+	message := sanitizeError(err)
+	setCondition(kind, secretv1beta1.ConditionSecretSynced, metav1.ConditionFalse, reason, message)
+	setCondition(kind, secretv1beta1.ConditionReady, metav1.ConditionFalse, reason, message)
+}
+
+// syncError is an error of writing a Secret with the reason of conditions.
+type syncError struct {
+	reason string
+	err    error
+}
+
+func (e *syncError) Error() string {
+	return e.err.Error()
+}
+
+func (e *syncError) Unwrap() error {
+	return e.err
+}
+
+func markSkipped(kind *secretv1beta1.KMSSecret) {
+	setCondition(kind, secretv1beta1.ConditionSecretSynced, metav1.ConditionTrue, secretv1beta1.ReasonSkipped, "Secret is not managed because creationPolicy is None")
+	if meta.IsStatusConditionFalse(kind.Status.Conditions, secretv1beta1.ConditionDecrypted) {
+		return
+	}
+	setCondition(kind, secretv1beta1.ConditionReady, metav1.ConditionTrue, secretv1beta1.ReasonSkipped, "All encrypted data are decrypted")
+}
+
+// dataStatuses returns the result of decryption for each key of encryptedData and each document of encryptedDataFrom, which is sorted by the key.
+func dataStatuses(kind *secretv1beta1.KMSSecret, errs map[string]error) []secretv1beta1.DataStatus {
+	if dataCount(kind) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, dataCount(kind))
+	for key := range kind.Spec.EncryptedData {
+		keys = append(keys, key)
+	}
+	for _, entry := range kind.Spec.EncryptedDataFrom {
+		if _, ok := kind.Spec.EncryptedData[entry.Name]; ok {
+			continue
+		}
+		keys = append(keys, entry.Name)
+	}
+	statuses := make([]secretv1beta1.DataStatus, 0, len(keys))
+	for _, key := range keys {
+		status := secretv1beta1.DataStatus{
+			Key:       key,
+			Decrypted: true,
+		}
+		if err, ok := errs[key]; ok {
+			status.Decrypted = false
+			status.Reason = errorClass(err)
+			status.Message = sanitizeError(err)
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Key < statuses[j].Key
+	})
+	return statuses
+}
+
+// errorClass returns the class of the decryption error, which is the error code for AWS errors.
+func errorClass(err error) string {
+	var aerr awserr.Error
+	switch {
+	case isTimeout(err):
+		return "Timeout"
+	case errors.As(err, &aerr):
+		return aerr.Code()
+	case errors.Is(err, decrypter.ErrKeyMismatch):
+		return "KeyMismatch"
+	case errors.Is(err, errReservedEncryptionContext):
+		return "InvalidEncryptionContext"
+	case errors.Is(err, errDecode):
+		return "DecodeFailed"
+	case errors.Is(err, errInvalidKey):
+		return "InvalidKey"
+	case errors.Is(err, errKeyConflict):
+		return "KeyConflict"
+	case errors.Is(err, errMissingRegion):
+		return "MissingRegion"
+	}
+	return "Unknown"
+}
+
+// statusChanged returns true if the status needs to be written.
+func statusChanged(old, new secretv1beta1.KMSSecretStatus) bool {
+	return !equality.Semantic.DeepEqual(old, new)
+}
+
+// writeStatus writes the status of obj through the status subresource, and retries it on conflicts with the latest object.
+// setStatus copies the status of obj into the latest object.
+func writeStatus(ctx context.Context, c client.Client, obj client.Object, setStatus func(latest client.Object)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := obj.DeepCopyObject().(client.Object)
+		if err := c.Get(ctx, client.ObjectKeyFromObject(obj), latest); err != nil {
+			return err
+		}
+		setStatus(latest)
+		return c.Status().Update(ctx, latest)
+	})
+}
+
+// sanitizeError returns a short single line message of err to record it in the status.
+// AWS errors are reduced to the error code and the message, so request IDs and wrapped errors are dropped.
+func sanitizeError(err error) string {
+	if err == nil {
+		return ""
+	}
+	message := err.Error()
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		message = strings.Replace(message, aerr.Error(), aerr.Code()+": "+aerr.Message(), 1)
+	}
+	message = strings.Join(strings.Fields(message), " ")
+	if len(message) > maxErrorLength {
+		message = message[:maxErrorLength] + "..."
+	}
+	return message
+}
@@ -0,0 +1,95 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var errTemplate = errors.New("failed to render template")
+
+// templateFuncs is the function set which is available in templates.
+// It only contains pure functions, so templates can not access files, environment variables or the network.
+var templateFuncs = template.FuncMap{
+	"b64enc": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"b64dec": func(s string) (string, error) {
+		res, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return "", errors.New("value is not base64 encoded")
+		}
+		return string(res), nil
+	},
+	"toJson": func(v interface{}) (string, error) {
+		res, err := json.Marshal(v)
+		if err != nil {
+			return "", errors.New("value could not be encoded as JSON")
+		}
+		return string(res), nil
+	},
+	"trim":  strings.TrimSpace,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"quote": strconv.Quote,
+}
+
+// renderTemplates renders the templates with the decrypted data, and returns the rendered keys.
+// Errors never contain the decrypted data, because they are recorded in the status.
+func renderTemplates(templates map[string]string, decryptedData map[string][]byte) (map[string][]byte, error) {
+	if len(templates) == 0 {
+		return nil, nil
+	}
+	values := make(map[string]string, len(decryptedData))
+	for k, v := range decryptedData {
+		values[k] = string(v)
+	}
+
+	keys := make([]string, 0, len(templates))
+	for k := range templates {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	res := make(map[string][]byte, len(templates))
+	for _, key := range keys {
+		if errs := validation.IsConfigMapKey(key); len(errs) > 0 {
+			return nil, fmt.Errorf("%w: %s is not a valid key of Secret: %s", errTemplate, key, strings.Join(errs, ", "))
+		}
+		tmpl, err := template.New(key).Option("missingkey=error").Funcs(templateFuncs).Parse(templates[key])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", errTemplate, key, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, values); err != nil {
+			// Functions in templateFuncs return errors without the value, so execution errors do not contain the decrypted data.
+			return nil, fmt.Errorf("%w: %s: %v", errTemplate, key, err)
+		}
+		res[key] = buf.Bytes()
+	}
+	return res, nil
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	decrypted := map[string][]byte{
+		"PASSWORD": []byte("p@ss"),
+		"tls.crt":  []byte(" cert\n"),
+		"ENCODED":  []byte("aG9nZQ=="),
+	}
+	cases := []struct {
+		title       string
+		template    string
+		expected    string
+		expectError bool
+	}{
+		{
+			title:    "plain field",
+			template: "postgres://admin:{{ .PASSWORD }}@db:5432/app",
+			expected: "postgres://admin:p@ss@db:5432/app",
+		},
+		{
+			title:    "index and trim",
+			template: `{{ index . "tls.crt" | trim | upper }}`,
+			expected: "CERT",
+		},
+		{
+			title:    "b64enc and b64dec",
+			template: "{{ .ENCODED | b64dec }}:{{ .PASSWORD | b64enc }}",
+			expected: "hoge:cEBzcw==",
+		},
+		{
+			title:    "toJson and quote",
+			template: `{{ toJson (index . "tls.crt") }} {{ quote .ENCODED }}`,
+			expected: `" cert\n" "aG9nZQ=="`,
+		},
+		{
+			title:       "missing key",
+			template:    "{{ .USERNAME }}",
+			expectError: true,
+		},
+		{
+			title:       "invalid base64",
+			template:    "{{ .PASSWORD | b64dec }}",
+			expectError: true,
+		},
+		{
+			title:       "syntax error",
+			template:    "{{ .PASSWORD",
+			expectError: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			res, err := renderTemplates(map[string]string{"OUTPUT": c.template}, decrypted)
+			if c.expectError {
+				if !errors.Is(err, errTemplate) {
+					t.Fatalf("renderTemplates should return errTemplate: %v", err)
+				}
+				if strings.Contains(err.Error(), "p@ss") {
+					t.Errorf("error contains the decrypted data: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(res["OUTPUT"]) != c.expected {
+				t.Errorf("rendered value is not matched, expected: %s, returned: %s", c.expected, res["OUTPUT"])
+			}
+		})
+	}
+
+	if _, err := renderTemplates(map[string]string{"invalid key": "hoge"}, decrypted); !errors.Is(err, errTemplate) {
+		t.Errorf("renderTemplates should reject invalid keys: %v", err)
+	}
+}
+
+func TestBuildSecretTemplate(t *testing.T) {
+	kind := newTestKMSSecret(nil)
+	kind.Spec.Template.Data = map[string]string{
+		"PASSWORD": "{{ .PASSWORD | trim }}",
+		"URL":      "postgres://admin:{{ .PASSWORD | trim }}@db",
+	}
+	decrypted := map[string][]byte{"PASSWORD": []byte(" hoge ")}
+	secret, err := buildSecret(*kind, secretTargets(kind)[0], decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["PASSWORD"]) != "hoge" {
+		t.Errorf("rendered keys should override decrypted keys, returned: %s", secret.Data["PASSWORD"])
+	}
+	if string(secret.Data["URL"]) != "postgres://admin:hoge@db" {
+		t.Errorf("URL is not matched, returned: %s", secret.Data["URL"])
+	}
+	if string(decrypted["PASSWORD"]) != " hoge " {
+		t.Errorf("decrypted data should not be modified, returned: %s", decrypted["PASSWORD"])
+	}
+}
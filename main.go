@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -28,6 +29,8 @@ import (
 
 	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
 	"github.com/h3poteto/kms-secrets/controllers"
+	"github.com/h3poteto/kms-secrets/pkg/decrypter"
+	"github.com/h3poteto/kms-secrets/webhooks"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -46,10 +49,46 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var allowCrossNamespaceTargets bool
+	var kmsRoleARN string
+	var disableDecryptCache bool
+	var decryptCacheTTL time.Duration
+	var decryptCacheSize int
+	var maxConcurrentReconciles int
+	var maxConcurrentDecryptionsPerSecret int
+	var maxConcurrentDecryptions int
+	var kmsTimeout time.Duration
+	var decryptTimeout time.Duration
+	var kmsQPS float64
+	var kmsBurst int
+	var permanentFailureRequeueInterval time.Duration
+	var enableWebhook bool
+	var webhookDryRunDecrypt bool
+	var defaultRegion string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&allowCrossNamespaceTargets, "allow-cross-namespace-targets", false,
+		"Allow KMSSecrets to write Secrets into other namespaces with spec.target.namespaces.")
+	flag.StringVar(&kmsRoleARN, "kms-role-arn", "", "The ARN of the IAM role which is assumed to call KMS. If it is empty, the default credentials are used.")
+	flag.BoolVar(&disableDecryptCache, "disable-decrypt-cache", false, "Disable the in-memory cache of decrypted data.")
+	flag.DurationVar(&decryptCacheTTL, "decrypt-cache-ttl", 5*time.Minute, "How long decrypted data are cached.")
+	flag.IntVar(&decryptCacheSize, "decrypt-cache-size", 1000, "The maximum number of cached decrypted data.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1, "The maximum number of KMSSecrets and ClusterKMSSecrets which are reconciled concurrently.")
+	flag.IntVar(&maxConcurrentDecryptionsPerSecret, "max-concurrent-decryptions-per-secret", 10, "The maximum number of keys which are decrypted concurrently in a reconciliation.")
+	flag.IntVar(&maxConcurrentDecryptions, "max-concurrent-decryptions", 50, "The maximum number of concurrent decryptions in the controller.")
+	flag.DurationVar(&kmsTimeout, "kms-timeout", 10*time.Second, "The timeout of each KMS call. Zero means no timeout.")
+	flag.DurationVar(&decryptTimeout, "decrypt-timeout", time.Minute, "The timeout of all decryptions in a reconciliation. Zero means no timeout.")
+	flag.Float64Var(&kmsQPS, "kms-qps", 100, "The maximum number of KMS calls per second in the controller. Zero or negative means no limit.")
+	flag.IntVar(&kmsBurst, "kms-burst", 100, "The maximum burst of KMS calls in the controller.")
+	flag.DurationVar(&permanentFailureRequeueInterval, "permanent-failure-requeue-interval", 10*time.Minute,
+		"The interval to retry decryptions which failed permanently, e.g. with a disabled key or a malformed ciphertext.")
+	flag.BoolVar(&enableWebhook, "enable-webhook", false, "Enable the validating webhook of KMSSecret. It requires the serving certificate of the webhook server.")
+	flag.BoolVar(&webhookDryRunDecrypt, "webhook-dry-run-decrypt", false,
+		"Decrypt the encrypted data in the validating webhook, and reject KMSSecrets which the controller can not decrypt. The plaintext is discarded.")
+	flag.StringVar(&defaultRegion, "default-region", "",
+		"The region of KMSSecrets which do not specify the region. The "+secretv1beta1.RegionAnnotation+" annotation of namespaces overrides it.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
@@ -66,15 +105,78 @@ func main() {
 		os.Exit(1)
 	}
 
+	var kmsDecrypter decrypter.Decrypter
+	kmsDecrypter, err = decrypter.NewKMSDecrypter(kmsRoleARN, kmsTimeout)
+	if err != nil {
+		setupLog.Error(err, "unable to create decrypter")
+		os.Exit(1)
+	}
+	// Cached results do not consume the global limit of concurrent decryptions.
+	kmsDecrypter = decrypter.NewLimitedDecrypter(kmsDecrypter, maxConcurrentDecryptions)
+	if kmsQPS > 0 {
+		// Requests wait for the rate limiter without holding a slot of the concurrent decryptions.
+		kmsDecrypter = decrypter.NewRateLimitedDecrypter(kmsDecrypter, kmsQPS, kmsBurst)
+	}
+	// The dry-run of the webhook discards the plaintext, so it does not use the cache.
+	dryRunDecrypter := kmsDecrypter
+	if !disableDecryptCache {
+		kmsDecrypter = decrypter.NewCachingDecrypter(kmsDecrypter, decryptCacheTTL, decryptCacheSize)
+	}
+
 	if err = (&controllers.KMSSecretReconciler{
-		Client:   mgr.GetClient(),
-		Log:      ctrl.Log.WithName("controllers").WithName("KMSSecret"),
-		Recorder: mgr.GetEventRecorderFor("mks-secret"),
-		Scheme:   mgr.GetScheme(),
+		Client:    mgr.GetClient(),
+		Log:       ctrl.Log.WithName("controllers").WithName("KMSSecret"),
+		Recorder:  mgr.GetEventRecorderFor("mks-secret"),
+		Scheme:    mgr.GetScheme(),
+		Decrypter: kmsDecrypter,
+
+		DefaultRegion:                   defaultRegion,
+		DecryptParallelism:              maxConcurrentDecryptionsPerSecret,
+		DecryptTimeout:                  decryptTimeout,
+		PermanentFailureRequeueInterval: permanentFailureRequeueInterval,
+		MaxConcurrentReconciles:         maxConcurrentReconciles,
+		AllowCrossNamespaceTargets:      allowCrossNamespaceTargets,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "KMSSecret")
 		os.Exit(1)
 	}
+	if err = (&controllers.ClusterKMSSecretReconciler{
+		Client:    mgr.GetClient(),
+		Log:       ctrl.Log.WithName("controllers").WithName("ClusterKMSSecret"),
+		Recorder:  mgr.GetEventRecorderFor("cluster-kms-secret"),
+		Scheme:    mgr.GetScheme(),
+		Decrypter: kmsDecrypter,
+
+		DefaultRegion:                   defaultRegion,
+		DecryptParallelism:              maxConcurrentDecryptionsPerSecret,
+		DecryptTimeout:                  decryptTimeout,
+		PermanentFailureRequeueInterval: permanentFailureRequeueInterval,
+		MaxConcurrentReconciles:         maxConcurrentReconciles,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterKMSSecret")
+		os.Exit(1)
+	}
+	if enableWebhook {
+		validator := &webhooks.KMSSecretValidator{}
+		if webhookDryRunDecrypt {
+			validator.Decrypter = dryRunDecrypter
+			validator.Client = mgr.GetClient()
+			validator.DefaultRegion = defaultRegion
+			validator.DecryptParallelism = maxConcurrentDecryptionsPerSecret
+		}
+		if err = validator.SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "KMSSecret")
+			os.Exit(1)
+		}
+		defaulter := &webhooks.KMSSecretDefaulter{
+			Client:        mgr.GetClient(),
+			DefaultRegion: defaultRegion,
+		}
+		if err = defaulter.SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "KMSSecret")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -0,0 +1,162 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package decrypter
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CachingDecrypter caches the results of another Decrypter in memory.
+// Entries expire after the TTL, and the least recently used entry is evicted when the cache is full.
+// Errors are never cached, so failed decryptions are retried with the backend.
+type CachingDecrypter struct {
+	next Decrypter
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type cacheEntry struct {
+	key     string
+	output  Output
+	expires time.Time
+}
+
+var _ Decrypter = &CachingDecrypter{}
+
+// NewCachingDecrypter returns a CachingDecrypter which caches at most size results of next for ttl.
+func NewCachingDecrypter(next Decrypter, ttl time.Duration, size int) *CachingDecrypter {
+	return &CachingDecrypter{
+		next:    next,
+		ttl:     ttl,
+		size:    size,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Decrypt returns the cached result if the same input has been decrypted, otherwise it decrypts the input with the next Decrypter.
+func (c *CachingDecrypter) Decrypt(ctx context.Context, input *Input) (*Output, error) {
+	key := cacheKey(input)
+	if output, ok := c.get(key); ok {
+		cacheHits.Inc()
+		return output, nil
+	}
+	cacheMisses.Inc()
+
+	output, err := c.next.Decrypt(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	c.add(key, output)
+	return copyOutput(output), nil
+}
+
+// Len returns the number of cached entries, including expired entries which are not evicted yet.
+func (c *CachingDecrypter) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *CachingDecrypter) get(key string) (*Output, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return copyOutput(&entry.output), true
+}
+
+func (c *CachingDecrypter) add(key string, output *Output) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &cacheEntry{
+		key:     key,
+		output:  *copyOutput(output),
+		expires: c.now().Add(c.ttl),
+	}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// copyOutput returns a copy of output, so callers can not modify the cached plaintext.
+func copyOutput(output *Output) *Output {
+	plaintext := make([]byte, len(output.Plaintext))
+	copy(plaintext, output.Plaintext)
+	return &Output{
+		Plaintext: plaintext,
+		KeyID:     output.KeyID,
+	}
+}
+
+// cacheKey returns the digest of the input. The plaintext can be obtained only with the same ciphertext, encryption context and key,
+// so all of them are included. Each field is prefixed with the length, so different inputs never have the same digest.
+func cacheKey(input *Input) string {
+	h := sha256.New()
+	writeField(h, []byte(input.Region))
+	writeField(h, []byte(input.KeyID))
+	keys := make([]string, 0, len(input.EncryptionContext))
+	for k := range input.EncryptionContext {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	binary.Write(h, binary.BigEndian, uint64(len(keys)))
+	for _, k := range keys {
+		writeField(h, []byte(k))
+		writeField(h, []byte(input.EncryptionContext[k]))
+	}
+	writeField(h, input.CiphertextBlob)
+	return string(h.Sum(nil))
+}
+
+func writeField(h hash.Hash, b []byte) {
+	binary.Write(h, binary.BigEndian, uint64(len(b)))
+	h.Write(b)
+}
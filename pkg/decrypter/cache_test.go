@@ -0,0 +1,85 @@
+package decrypter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCachingDecrypter(t *testing.T) {
+	fake := NewFakeDecrypter()
+	fake.Add([]byte("encrypted-hoge"), []byte("hoge"))
+	fake.AddWithEncryptionContext([]byte("encrypted-fuga"), []byte("fuga"), map[string]string{"app": "web"})
+	now := time.Now()
+	c := NewCachingDecrypter(fake, time.Minute, 2)
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		output, err := c.Decrypt(ctx, &Input{Region: "us-east-1", CiphertextBlob: []byte("encrypted-hoge")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output.Plaintext) != "hoge" {
+			t.Errorf("plaintext is not matched, expected: hoge, returned: %s", output.Plaintext)
+		}
+		output.Plaintext[0] = 'x'
+	}
+	if fake.Calls() != 1 {
+		t.Errorf("second decryption should be served from the cache, calls: %d", fake.Calls())
+	}
+
+	// Other regions, keys or encryption contexts are not served from the cache.
+	if _, err := c.Decrypt(ctx, &Input{Region: "us-west-2", CiphertextBlob: []byte("encrypted-hoge")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Decrypt(ctx, &Input{Region: "us-east-1", CiphertextBlob: []byte("encrypted-fuga")}); !errors.Is(err, ErrUnknownCiphertext) {
+		t.Errorf("decryption without the encryption context should fail: %v", err)
+	}
+	if _, err := c.Decrypt(ctx, &Input{Region: "us-east-1", CiphertextBlob: []byte("encrypted-fuga"), EncryptionContext: map[string]string{"app": "web"}}); err != nil {
+		t.Fatal(err)
+	}
+	if fake.Calls() != 4 {
+		t.Errorf("different inputs should not be served from the cache, calls: %d", fake.Calls())
+	}
+
+	// The least recently used entry is evicted.
+	if c.Len() != 2 {
+		t.Errorf("cache should be bounded by the size, length: %d", c.Len())
+	}
+	if _, err := c.Decrypt(ctx, &Input{Region: "us-east-1", CiphertextBlob: []byte("encrypted-hoge")}); err != nil {
+		t.Fatal(err)
+	}
+	if fake.Calls() != 5 {
+		t.Errorf("evicted entry should be decrypted again, calls: %d", fake.Calls())
+	}
+
+	// Entries expire after the TTL.
+	now = now.Add(2 * time.Minute)
+	if _, err := c.Decrypt(ctx, &Input{Region: "us-east-1", CiphertextBlob: []byte("encrypted-hoge")}); err != nil {
+		t.Fatal(err)
+	}
+	if fake.Calls() != 6 {
+		t.Errorf("expired entry should be decrypted again, calls: %d", fake.Calls())
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	base := &Input{Region: "us-east-1", CiphertextBlob: []byte("ab"), EncryptionContext: map[string]string{"a": "b"}}
+	cases := []*Input{
+		{Region: "us-east-1", CiphertextBlob: []byte("ab")},
+		{Region: "us-east-1", CiphertextBlob: []byte("ab"), EncryptionContext: map[string]string{"a": "c"}},
+		{Region: "us-east-1", CiphertextBlob: []byte("ab"), EncryptionContext: map[string]string{"a": "b"}, KeyID: "key"},
+		{Region: "us-east-1a", CiphertextBlob: []byte("b"), EncryptionContext: map[string]string{"a": "b"}},
+	}
+	for _, c := range cases {
+		if cacheKey(base) == cacheKey(c) {
+			t.Errorf("cache key should differ: %#v", c)
+		}
+	}
+	same := &Input{Region: "us-east-1", CiphertextBlob: []byte("ab"), EncryptionContext: map[string]string{"a": "b"}}
+	if cacheKey(base) != cacheKey(same) {
+		t.Errorf("cache key should be the same for the same input")
+	}
+}
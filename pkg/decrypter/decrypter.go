@@ -0,0 +1,51 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package decrypter provides backends which decrypt the encrypted data of KMSSecret.
+package decrypter
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrKeyMismatch is returned when the ciphertext is not encrypted with the expected key.
+var ErrKeyMismatch = errors.New("key mismatch")
+
+// Decrypter decrypts a ciphertext and returns the plaintext.
+type Decrypter interface {
+	Decrypt(ctx context.Context, input *Input) (*Output, error)
+}
+
+// Input is the parameter of Decrypt.
+type Input struct {
+	// Region is the region of the backend which encrypted the ciphertext.
+	Region string
+	// CiphertextBlob is the encrypted data.
+	CiphertextBlob []byte
+	// EncryptionContext is the encryption context which is used to encrypt the data.
+	EncryptionContext map[string]string
+	// KeyID is the expected key to decrypt the data. If it is empty, any key is allowed.
+	KeyID string
+}
+
+// Output is the result of Decrypt.
+type Output struct {
+	// Plaintext is the decrypted data.
+	Plaintext []byte
+	// KeyID is the key which is used to decrypt the data.
+	KeyID string
+}
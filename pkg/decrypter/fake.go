@@ -0,0 +1,115 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package decrypter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// ErrUnknownCiphertext is returned by FakeDecrypter when the ciphertext is not registered,
+// or when the encryption context does not match the registered one.
+var ErrUnknownCiphertext = errors.New("unknown ciphertext")
+
+// FakeEntry is a ciphertext registered in FakeDecrypter.
+type FakeEntry struct {
+	// Plaintext is returned when the ciphertext is decrypted.
+	Plaintext []byte
+	// EncryptionContext is the encryption context which the ciphertext is encrypted with.
+	EncryptionContext map[string]string
+	// KeyID is the key which the ciphertext is encrypted with.
+	KeyID string
+}
+
+// FakeDecrypter is an in-memory Decrypter for tests.
+// It returns the plaintext which is registered for the ciphertext with Add.
+type FakeDecrypter struct {
+	mu         sync.Mutex
+	plaintexts map[string]FakeEntry
+	calls      int
+}
+
+var _ Decrypter = &FakeDecrypter{}
+
+// NewFakeDecrypter returns an empty FakeDecrypter.
+func NewFakeDecrypter() *FakeDecrypter {
+	return &FakeDecrypter{
+		plaintexts: make(map[string]FakeEntry),
+	}
+}
+
+// Add registers the plaintext for the ciphertext.
+func (f *FakeDecrypter) Add(ciphertext, plaintext []byte) {
+	f.AddEntry(ciphertext, FakeEntry{Plaintext: plaintext})
+}
+
+// AddWithEncryptionContext registers the plaintext for the ciphertext which is encrypted with the encryption context.
+// Decrypt fails unless the same encryption context is given, as AWS KMS does.
+func (f *FakeDecrypter) AddWithEncryptionContext(ciphertext, plaintext []byte, encryptionContext map[string]string) {
+	f.AddEntry(ciphertext, FakeEntry{Plaintext: plaintext, EncryptionContext: encryptionContext})
+}
+
+// AddEntry registers the entry for the ciphertext.
+func (f *FakeDecrypter) AddEntry(ciphertext []byte, entry FakeEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.plaintexts[string(ciphertext)] = entry
+}
+
+// Calls returns how many times Decrypt has been called.
+func (f *FakeDecrypter) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// Decrypt returns the registered plaintext for the ciphertext.
+func (f *FakeDecrypter) Decrypt(ctx context.Context, input *Input) (*Output, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	entry, ok := f.plaintexts[string(input.CiphertextBlob)]
+	if !ok || !equalContext(entry.EncryptionContext, input.EncryptionContext) {
+		return nil, ErrUnknownCiphertext
+	}
+	if input.KeyID != "" && input.KeyID != entry.KeyID {
+		// KMS rejects the ciphertext with IncorrectKeyException, and KMSDecrypter converts it into ErrKeyMismatch.
+		err := awserr.New(kms.ErrCodeIncorrectKeyException, fmt.Sprintf("ciphertext is encrypted with %s", entry.KeyID), nil)
+		return nil, keyMismatchError(input, err)
+	}
+	return &Output{
+		Plaintext: entry.Plaintext,
+		KeyID:     entry.KeyID,
+	}, nil
+}
+
+func equalContext(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
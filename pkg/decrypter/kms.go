@@ -0,0 +1,145 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package decrypter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+)
+
+// KMSDecrypter decrypts data using AWS KMS.
+// KMS clients are created once for each region and reused, so the shared config and the credentials are not loaded for every decryption.
+// It is safe for concurrent use.
+type KMSDecrypter struct {
+	sess *session.Session
+	// creds is the credentials of the assumed role. If it is nil, the credentials of the session are used.
+	creds *credentials.Credentials
+	// timeout bounds each call to KMS. If it is zero, calls are bound only by the context of Decrypt.
+	timeout time.Duration
+
+	mu      sync.Mutex
+	clients map[string]kmsiface.KMSAPI
+}
+
+var _ Decrypter = &KMSDecrypter{}
+
+// NewKMSDecrypter returns a KMSDecrypter which uses the shared AWS config and credentials.
+// If roleARN is not empty, the role is assumed to call KMS, and the credentials are refreshed by the SDK before they expire.
+// Each call to KMS is canceled after timeout, even if the context of Decrypt has no deadline.
+func NewKMSDecrypter(roleARN string, timeout time.Duration) (*KMSDecrypter, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+	return newKMSDecrypter(sess, roleARN, timeout), nil
+}
+
+func newKMSDecrypter(sess *session.Session, roleARN string, timeout time.Duration) *KMSDecrypter {
+	d := &KMSDecrypter{
+		sess:    sess,
+		timeout: timeout,
+		clients: make(map[string]kmsiface.KMSAPI),
+	}
+	if roleARN != "" {
+		d.creds = stscreds.NewCredentials(sess, roleARN)
+	}
+	return d
+}
+
+// client returns the KMS client for the region, which is created at the first call.
+func (d *KMSDecrypter) client(region string) kmsiface.KMSAPI {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if svc, ok := d.clients[region]; ok {
+		return svc
+	}
+	config := aws.NewConfig().WithRegion(region)
+	if d.creds != nil {
+		config = config.WithCredentials(d.creds)
+	}
+	svc := kms.New(d.sess, config)
+	d.clients[region] = svc
+	return svc
+}
+
+// Decrypt decrypts the ciphertext with AWS KMS in the given region.
+// The request is canceled when ctx is done, and the error wraps the error of the context in that case.
+func (d *KMSDecrypter) Decrypt(ctx context.Context, input *Input) (*Output, error) {
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	output, err := d.decrypt(ctx, input)
+	kmsDecryptDuration.WithLabelValues(input.Region).Observe(time.Since(start).Seconds())
+	if err != nil && ctx.Err() != nil {
+		// The SDK returns RequestCanceled for both of the deadline and the cancellation, so return the cause instead.
+		err = fmt.Errorf("KMS request in %s is canceled: %w", input.Region, ctx.Err())
+	}
+	// The key ID of the input is used for all results, so the results of the same key are in the same series.
+	kmsDecryptCalls.WithLabelValues(input.Region, input.KeyID, resultCode(err)).Inc()
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (d *KMSDecrypter) decrypt(ctx context.Context, input *Input) (*Output, error) {
+	svc := d.client(input.Region)
+	decryptInput := &kms.DecryptInput{
+		CiphertextBlob: input.CiphertextBlob,
+	}
+	if len(input.EncryptionContext) > 0 {
+		decryptInput.EncryptionContext = aws.StringMap(input.EncryptionContext)
+	}
+	if input.KeyID != "" {
+		decryptInput.KeyId = aws.String(input.KeyID)
+	}
+	decrypted, err := svc.DecryptWithContext(ctx, decryptInput)
+	if err != nil {
+		return nil, keyMismatchError(input, err)
+	}
+	return &Output{
+		Plaintext: decrypted.Plaintext,
+		KeyID:     aws.StringValue(decrypted.KeyId),
+	}, nil
+}
+
+// keyMismatchError converts IncorrectKeyException into ErrKeyMismatch.
+// KMS rejects a ciphertext which is encrypted with other keys than KeyId by itself, including aliases, so the key is not verified again.
+// Other errors are returned as is.
+func keyMismatchError(input *Input, err error) error {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == kms.ErrCodeIncorrectKeyException {
+		return fmt.Errorf("%w: ciphertext is not encrypted with %s: %s", ErrKeyMismatch, input.KeyID, aerr.Message())
+	}
+	return err
+}
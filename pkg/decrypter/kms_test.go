@@ -0,0 +1,104 @@
+package decrypter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestKMSDecrypterClient(t *testing.T) {
+	sess, err := session.NewSession(aws.NewConfig().WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newKMSDecrypter(sess, "", 0)
+	east := d.client("us-east-1")
+	if d.client("us-east-1") != east {
+		t.Errorf("client should be reused for the same region")
+	}
+	west := d.client("us-west-2")
+	if west == east {
+		t.Errorf("client should be created for each region")
+	}
+	if region := aws.StringValue(west.(*kms.KMS).Config.Region); region != "us-west-2" {
+		t.Errorf("region of client is not matched, expected: us-west-2, returned: %s", region)
+	}
+
+	d = newKMSDecrypter(sess, "arn:aws:iam::123456789012:role/kms-secrets", 0)
+	if d.client("us-east-1").(*kms.KMS).Config.Credentials != d.creds {
+		t.Errorf("client should use the credentials of the assumed role")
+	}
+}
+
+// incorrectKeyKMS is a KMS client which rejects every ciphertext as encrypted with other keys.
+type incorrectKeyKMS struct {
+	kmsiface.KMSAPI
+}
+
+func (i *incorrectKeyKMS) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	return nil, awserr.New(kms.ErrCodeIncorrectKeyException, "The key ID in the request does not identify a CMK that can perform this operation.", nil)
+}
+
+func TestKMSDecrypterIncorrectKey(t *testing.T) {
+	sess, err := session.NewSession(aws.NewConfig().WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newKMSDecrypter(sess, "", 0)
+	d.clients["us-east-1"] = &incorrectKeyKMS{}
+	mismatches := kmsDecryptCalls.WithLabelValues("us-east-1", "expected-key", "KeyMismatch")
+	before := testutil.ToFloat64(mismatches)
+	_, err = d.Decrypt(context.Background(), &Input{Region: "us-east-1", KeyID: "expected-key"})
+	if !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("IncorrectKeyException should be ErrKeyMismatch: %v", err)
+	}
+	if v := testutil.ToFloat64(mismatches) - before; v != 1 {
+		t.Errorf("failed call should be counted with the key ID of the input once, but %v", v)
+	}
+}
+
+// hangingKMS is a KMS client which does not respond until the context is done.
+type hangingKMS struct {
+	kmsiface.KMSAPI
+}
+
+func (h *hangingKMS) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	<-ctx.Done()
+	return nil, awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+}
+
+func TestKMSDecrypterTimeout(t *testing.T) {
+	sess, err := session.NewSession(aws.NewConfig().WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newKMSDecrypter(sess, "", 10*time.Millisecond)
+	d.clients["us-east-1"] = &hangingKMS{}
+	timeouts := kmsDecryptCalls.WithLabelValues("us-east-1", "", "Timeout")
+	before := testutil.ToFloat64(timeouts)
+	if _, err := d.Decrypt(context.Background(), &Input{Region: "us-east-1"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error should be context.DeadlineExceeded: %v", err)
+	}
+	if v := testutil.ToFloat64(timeouts) - before; v != 1 {
+		t.Errorf("timed out call should be counted once, but %v", v)
+	}
+
+	// The context of Decrypt cancels the request without the timeout.
+	d = newKMSDecrypter(sess, "", 0)
+	d.clients["us-east-1"] = &hangingKMS{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.Decrypt(ctx, &Input{Region: "us-east-1"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("error should be context.Canceled: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package decrypter
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// LimitedDecrypter bounds the number of concurrent decryptions of another Decrypter.
+// The limit is shared by all reconciliations, so a burst of reconciliations at start up does not flood the backend.
+type LimitedDecrypter struct {
+	next Decrypter
+	sem  chan struct{}
+}
+
+var _ Decrypter = &LimitedDecrypter{}
+
+// NewLimitedDecrypter returns a LimitedDecrypter which calls next at most limit times concurrently.
+func NewLimitedDecrypter(next Decrypter, limit int) *LimitedDecrypter {
+	if limit <= 0 {
+		limit = 1
+	}
+	return &LimitedDecrypter{
+		next: next,
+		sem:  make(chan struct{}, limit),
+	}
+}
+
+// Decrypt waits for a free slot and decrypts the input with the next Decrypter.
+// It returns the error of the context if the context is done while waiting.
+func (l *LimitedDecrypter) Decrypt(ctx context.Context, input *Input) (*Output, error) {
+	select {
+	case l.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() {
+		<-l.sem
+	}()
+	return l.next.Decrypt(ctx, input)
+}
+
+// RateLimitedDecrypter bounds the rate of decryptions of another Decrypter.
+// It keeps the controller below the request quota of KMS, which is shared by all clients in the account and the region.
+type RateLimitedDecrypter struct {
+	next    Decrypter
+	limiter *rate.Limiter
+}
+
+var _ Decrypter = &RateLimitedDecrypter{}
+
+// NewRateLimitedDecrypter returns a RateLimitedDecrypter which calls next at most qps times per second with the burst.
+func NewRateLimitedDecrypter(next Decrypter, qps float64, burst int) *RateLimitedDecrypter {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &RateLimitedDecrypter{
+		next:    next,
+		limiter: rate.NewLimiter(rate.Limit(qps), burst),
+	}
+}
+
+// Decrypt waits for the rate limiter and decrypts the input with the next Decrypter.
+// It returns an error if the context is done before the request is allowed.
+func (l *RateLimitedDecrypter) Decrypt(ctx context.Context, input *Input) (*Output, error) {
+	if err := l.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return l.next.Decrypt(ctx, input)
+}
@@ -0,0 +1,82 @@
+package decrypter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type blockingDecrypter struct {
+	release chan struct{}
+	running int32
+	max     int32
+}
+
+func (b *blockingDecrypter) Decrypt(ctx context.Context, input *Input) (*Output, error) {
+	n := atomic.AddInt32(&b.running, 1)
+	for {
+		m := atomic.LoadInt32(&b.max)
+		if n <= m || atomic.CompareAndSwapInt32(&b.max, m, n) {
+			break
+		}
+	}
+	<-b.release
+	atomic.AddInt32(&b.running, -1)
+	return &Output{Plaintext: input.CiphertextBlob}, nil
+}
+
+func TestLimitedDecrypter(t *testing.T) {
+	b := &blockingDecrypter{release: make(chan struct{})}
+	l := NewLimitedDecrypter(b, 2)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := l.Decrypt(ctx, &Input{CiphertextBlob: []byte("hoge")}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		b.release <- struct{}{}
+	}
+	wg.Wait()
+	if max := atomic.LoadInt32(&b.max); max > 2 {
+		t.Errorf("concurrent decryptions should be limited to 2, but %d", max)
+	}
+
+	// Waiting for a slot is canceled with the context.
+	full := NewLimitedDecrypter(b, 1)
+	full.sem <- struct{}{}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := full.Decrypt(canceled, &Input{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("error should be context.Canceled: %v", err)
+	}
+}
+
+func TestRateLimitedDecrypter(t *testing.T) {
+	fake := NewFakeDecrypter()
+	fake.Add([]byte("encrypted-hoge"), []byte("hoge"))
+	l := NewRateLimitedDecrypter(fake, 1, 1)
+	ctx := context.Background()
+
+	if _, err := l.Decrypt(ctx, &Input{CiphertextBlob: []byte("encrypted-hoge")}); err != nil {
+		t.Fatal(err)
+	}
+	// The burst is consumed, so the next request can not be allowed before the deadline.
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Decrypt(short, &Input{CiphertextBlob: []byte("encrypted-hoge")}); err == nil {
+		t.Errorf("request should be rate limited")
+	}
+	if fake.Calls() != 1 {
+		t.Errorf("rate limited request should not be sent, calls: %d", fake.Calls())
+	}
+}
@@ -0,0 +1,68 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package decrypter
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	cacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kms_secrets_decrypt_cache_hits_total",
+		Help: "Number of decryptions which are served from the cache",
+	})
+	cacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kms_secrets_decrypt_cache_misses_total",
+		Help: "Number of decryptions which are not found in the cache",
+	})
+	kmsDecryptCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kms_secrets_kms_decrypt_total",
+		Help: "Number of KMS Decrypt calls by region, key ID and result",
+	}, []string{"region", "key_id", "result"})
+	kmsDecryptDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kms_secrets_kms_decrypt_duration_seconds",
+		Help:    "Latency of KMS Decrypt calls",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"region"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(cacheHits, cacheMisses, kmsDecryptCalls, kmsDecryptDuration)
+}
+
+// resultCode returns the result label of a KMS call, which is the error code for AWS errors.
+func resultCode(err error) string {
+	var aerr awserr.Error
+	switch {
+	case err == nil:
+		return "Success"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "Timeout"
+	case errors.Is(err, context.Canceled):
+		return "Canceled"
+	case errors.Is(err, ErrKeyMismatch):
+		return "KeyMismatch"
+	case errors.As(err, &aerr):
+		return aerr.Code()
+	}
+	return "Unknown"
+}
@@ -0,0 +1,28 @@
+package decrypter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestResultCode(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected string
+	}{
+		{err: nil, expected: "Success"},
+		{err: fmt.Errorf("canceled: %w", context.DeadlineExceeded), expected: "Timeout"},
+		{err: fmt.Errorf("canceled: %w", context.Canceled), expected: "Canceled"},
+		{err: fmt.Errorf("%w: expected a, but b", ErrKeyMismatch), expected: "KeyMismatch"},
+		{err: awserr.New("DisabledException", "key is disabled", nil), expected: "DisabledException"},
+		{err: fmt.Errorf("unknown"), expected: "Unknown"},
+	}
+	for _, c := range cases {
+		if code := resultCode(c.err); code != c.expected {
+			t.Errorf("result code of %v is not matched, expected: %s, returned: %s", c.err, c.expected, code)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
+)
+
+// KMSSecretDefaulter fills the region of KMSSecrets which do not specify it,
+// so the same manifests can be applied to clusters in different regions.
+type KMSSecretDefaulter struct {
+	// Client reads the region annotation of namespaces.
+	Client client.Reader
+	// DefaultRegion is the region of KMSSecrets in namespaces without the region annotation.
+	DefaultRegion string
+
+	decoder *admission.Decoder
+}
+
+var _ admission.CustomDefaulter = &KMSSecretDefaulter{}
+var _ admission.Handler = &KMSSecretDefaulter{}
+
+// +kubebuilder:webhook:path=/mutate-secret-h3poteto-dev-v1beta1-kmssecret,mutating=true,failurePolicy=fail,sideEffects=None,groups=secret.h3poteto.dev,resources=kmssecrets,verbs=create,versions=v1beta1,name=mkmssecret.secret.h3poteto.dev,admissionReviewVersions=v1
+
+// SetupWebhookWithManager registers the defaulting webhook of KMSSecret to the manager.
+// The webhook is registered as a handler, because CustomDefaulter can not know the operation of the request.
+func (d *KMSSecretDefaulter) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register("/mutate-secret-h3poteto-dev-v1beta1-kmssecret", &webhook.Admission{Handler: d})
+	return nil
+}
+
+// InjectDecoder injects the decoder of admission requests.
+func (d *KMSSecretDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.decoder = decoder
+	return nil
+}
+
+// Handle defaults the KMSSecret only on creation.
+// Updates are not defaulted, otherwise a metadata-only update would change the region when the namespace annotation or the default region is changed.
+func (d *KMSSecretDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create {
+		return admission.Allowed("region is defaulted only on creation")
+	}
+	kind := &secretv1beta1.KMSSecret{}
+	if err := d.decoder.Decode(req, kind); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if err := d.Default(ctx, kind); err != nil {
+		return admission.Denied(err.Error())
+	}
+	marshalled, err := json.Marshal(kind)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshalled)
+}
+
+// Default sets the region annotation of the namespace or the default region to the KMSSecret without the region.
+// If neither of them is specified, the region is left empty and the controller reports it.
+func (d *KMSSecretDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	kind, ok := obj.(*secretv1beta1.KMSSecret)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a KMSSecret but got a %T", obj))
+	}
+	if kind.Spec.Region != "" || !kind.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	ns := corev1.Namespace{}
+	if err := d.Client.Get(ctx, client.ObjectKey{Name: kind.Namespace}, &ns); err != nil {
+		return fmt.Errorf("failed to get namespace %s: %w", kind.Namespace, err)
+	}
+	if region := ns.Annotations[secretv1beta1.RegionAnnotation]; region != "" {
+		kind.Spec.Region = region
+		return nil
+	}
+	kind.Spec.Region = d.DefaultRegion
+	return nil
+}
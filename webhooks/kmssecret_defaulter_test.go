@@ -0,0 +1,106 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
+)
+
+func TestDefault(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "tokyo",
+			Annotations: map[string]string{secretv1beta1.RegionAnnotation: "ap-northeast-1"},
+		}},
+	).Build()
+	d := &KMSSecretDefaulter{Client: c, DefaultRegion: "us-east-1"}
+
+	cases := []struct {
+		title     string
+		namespace string
+		region    string
+		expected  string
+	}{
+		{
+			title:     "default region",
+			namespace: "default",
+			expected:  "us-east-1",
+		},
+		{
+			title:     "namespace annotation",
+			namespace: "tokyo",
+			expected:  "ap-northeast-1",
+		},
+		{
+			title:     "specified region",
+			namespace: "tokyo",
+			region:    "eu-west-1",
+			expected:  "eu-west-1",
+		},
+	}
+	for _, c := range cases {
+		kind := newTestKMSSecret(secretv1beta1.KMSSecretSpec{Region: c.region})
+		kind.Namespace = c.namespace
+		if err := d.Default(context.Background(), kind); err != nil {
+			t.Fatalf("%s: %v", c.title, err)
+		}
+		if kind.Spec.Region != c.expected {
+			t.Errorf("%s: region is not matched, expected: %s, returned: %s", c.title, c.expected, kind.Spec.Region)
+		}
+	}
+}
+
+func TestHandleDefaultOnlyOnCreate(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = secretv1beta1.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+	).Build()
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &KMSSecretDefaulter{Client: c, DefaultRegion: "us-east-1"}
+	if err := d.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	kind := newTestKMSSecret(secretv1beta1.KMSSecretSpec{StringData: map[string]string{"HOST": "example.com"}})
+	kind.APIVersion = secretv1beta1.GroupVersion.String()
+	kind.Kind = "KMSSecret"
+	raw, err := json.Marshal(kind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(operation admissionv1.Operation) admission.Request {
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: operation,
+			Object:    runtime.RawExtension{Raw: raw},
+		}}
+	}
+
+	res := d.Handle(context.Background(), request(admissionv1.Create))
+	if !res.Allowed || len(res.Patches) != 1 || res.Patches[0].Path != "/spec/region" || res.Patches[0].Value != "us-east-1" {
+		t.Errorf("region should be defaulted on creation: %#v", res)
+	}
+
+	// Metadata-only updates, e.g. adding the finalizer, must not change the spec.
+	res = d.Handle(context.Background(), request(admissionv1.Update))
+	if !res.Allowed || len(res.Patches) != 0 {
+		t.Errorf("spec should be left untouched on update: %#v", res)
+	}
+}
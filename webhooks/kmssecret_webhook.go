@@ -0,0 +1,279 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhooks provides admission webhooks which reject broken KMSSecrets before they are stored.
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
+	"github.com/h3poteto/kms-secrets/controllers"
+	"github.com/h3poteto/kms-secrets/pkg/decrypter"
+)
+
+const (
+	// ciphertextVersion is the first byte of ciphertext blobs which are returned from KMS Encrypt with symmetric keys.
+	ciphertextVersion = 0x01
+	// maxCiphertextLength is the maximum length of ciphertext blobs which KMS accepts.
+	maxCiphertextLength = 6144
+	// maxPlaintextLength is the maximum length of plaintexts which KMS encrypts.
+	maxPlaintextLength = 4096
+)
+
+// defaultDryRunTimeout bounds the dry-run decryption, so the webhook responds before the API server gives up.
+const defaultDryRunTimeout = 5 * time.Second
+
+// KMSSecretValidator validates KMSSecrets when they are created or updated.
+type KMSSecretValidator struct {
+	// Decrypter decrypts the encrypted data in the dry-run. If it is nil, the encrypted data are validated only in the shape.
+	Decrypter decrypter.Decrypter
+	// Client reads the namespace to resolve the region in the dry-run.
+	Client client.Reader
+	// DefaultRegion is the region of KMSSecrets which do not specify the region in the dry-run.
+	DefaultRegion string
+	// DecryptParallelism is the number of keys which are decrypted concurrently in the dry-run.
+	DecryptParallelism int
+	// DryRunTimeout bounds the dry-run decryption. Transient failures, including the timeout, are allowed.
+	DryRunTimeout time.Duration
+}
+
+var _ admission.CustomValidator = &KMSSecretValidator{}
+
+// +kubebuilder:webhook:path=/validate-secret-h3poteto-dev-v1beta1-kmssecret,mutating=false,failurePolicy=fail,sideEffects=None,groups=secret.h3poteto.dev,resources=kmssecrets,verbs=create;update,versions=v1beta1,name=vkmssecret.secret.h3poteto.dev,admissionReviewVersions=v1
+
+// SetupWebhookWithManager registers the validating webhook of KMSSecret to the manager.
+func (v *KMSSecretValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&secretv1beta1.KMSSecret{}).
+		WithValidator(v).
+		Complete()
+}
+
+// ValidateCreate validates the spec of the new KMSSecret.
+func (v *KMSSecretValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	kind, ok := obj.(*secretv1beta1.KMSSecret)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a KMSSecret but got a %T", obj))
+	}
+	return invalid(kind, v.validate(ctx, kind))
+}
+
+// ValidateUpdate validates the spec of the updated KMSSecret.
+// Updates which do not change the spec are always allowed, so KMSSecrets which were created before the webhook can be finalized.
+func (v *KMSSecretValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	old, ok := oldObj.(*secretv1beta1.KMSSecret)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a KMSSecret but got a %T", oldObj))
+	}
+	kind, ok := newObj.(*secretv1beta1.KMSSecret)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a KMSSecret but got a %T", newObj))
+	}
+	if !kind.DeletionTimestamp.IsZero() || equality.Semantic.DeepEqual(old.Spec, kind.Spec) {
+		return nil
+	}
+	return invalid(kind, v.validate(ctx, kind))
+}
+
+// ValidateDelete allows all deletions.
+func (v *KMSSecretValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+// validate validates the spec, and decrypts the encrypted data if the dry-run is enabled and the spec is valid.
+func (v *KMSSecretValidator) validate(ctx context.Context, kind *secretv1beta1.KMSSecret) field.ErrorList {
+	path := field.NewPath("spec")
+	errs := validateSpec(&kind.Spec, path)
+	if len(errs) > 0 || v.Decrypter == nil {
+		return errs
+	}
+	timeout := v.DryRunTimeout
+	if timeout <= 0 {
+		timeout = defaultDryRunTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	failures, err := controllers.DryRunDecrypt(ctx, v.Client, v.Decrypter, kind, v.DefaultRegion, v.DecryptParallelism)
+	if err != nil {
+		errs = append(errs, field.Required(path.Child("region"), err.Error()))
+	}
+	for key, message := range failures {
+		// Errors of documents are reported with the name of encryptedDataFrom.
+		p := path.Child("encryptedData").Key(key)
+		for i := range kind.Spec.EncryptedDataFrom {
+			if kind.Spec.EncryptedDataFrom[i].Name == key {
+				p = path.Child("encryptedDataFrom").Index(i).Child("encryptedData")
+				break
+			}
+		}
+		errs = append(errs, field.Invalid(p, "<omitted>", "controller can not decrypt the value: "+message))
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+	return errs
+}
+
+func invalid(kind *secretv1beta1.KMSSecret, errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(secretv1beta1.GroupVersion.WithKind("KMSSecret").GroupKind(), kind.Name, errs)
+}
+
+// validateSpec returns the errors of the spec which would fail in the controller.
+// Ciphertexts are validated only in the shape, because they can be decrypted only with the credentials of the controller.
+func validateSpec(spec *secretv1beta1.KMSSecretSpec, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+	if len(spec.EncryptedData) == 0 && len(spec.EncryptedDataFrom) == 0 && len(spec.Data) == 0 && len(spec.StringData) == 0 {
+		errs = append(errs, field.Required(path.Child("encryptedData"), "one of encryptedData, encryptedDataFrom, data or stringData must be specified"))
+	}
+	if spec.Region != "" && !knownRegion(spec.Region) {
+		errs = append(errs, field.NotSupported(path.Child("region"), spec.Region, knownRegions()))
+	}
+
+	for key, value := range spec.EncryptedData {
+		p := path.Child("encryptedData").Key(key)
+		errs = append(errs, validateKey(p, key)...)
+		errs = append(errs, validateCiphertext(p, value)...)
+	}
+	for i := range spec.EncryptedDataFrom {
+		entry := &spec.EncryptedDataFrom[i]
+		p := path.Child("encryptedDataFrom").Index(i)
+		errs = append(errs, validateCiphertext(p.Child("encryptedData"), entry.EncryptedData)...)
+		if entry.Prefix != "" {
+			errs = append(errs, validateKey(p.Child("prefix"), entry.Prefix)...)
+		}
+		for j, key := range entry.Keys {
+			errs = append(errs, validateKey(p.Child("keys").Index(j), key)...)
+		}
+	}
+	for key := range spec.Data {
+		p := path.Child("data").Key(key)
+		errs = append(errs, validateKey(p, key)...)
+		if _, ok := spec.StringData[key]; ok {
+			errs = append(errs, field.Forbidden(p, "data and stringData must not have the same key"))
+		}
+		if _, ok := spec.EncryptedData[key]; ok {
+			errs = append(errs, field.Forbidden(p, "data and encryptedData must not have the same key"))
+		}
+	}
+	for key := range spec.StringData {
+		p := path.Child("stringData").Key(key)
+		errs = append(errs, validateKey(p, key)...)
+		if _, ok := spec.EncryptedData[key]; ok {
+			errs = append(errs, field.Forbidden(p, "stringData and encryptedData must not have the same key"))
+		}
+	}
+	for key := range spec.Template.Data {
+		errs = append(errs, validateKey(path.Child("template", "data").Key(key), key)...)
+	}
+
+	if size := estimatedSecretSize(spec); size > corev1.MaxSecretSize {
+		errs = append(errs, field.Invalid(path, fmt.Sprintf("%d bytes", size), fmt.Sprintf("estimated size of the generated Secret must be at most %d bytes", corev1.MaxSecretSize)))
+	}
+	// Keys of maps are iterated in random order, so sort the errors to return the same message for the same spec.
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+	return errs
+}
+
+func validateKey(path *field.Path, key string) field.ErrorList {
+	errs := field.ErrorList{}
+	for _, msg := range validation.IsConfigMapKey(key) {
+		errs = append(errs, field.Invalid(path, key, msg))
+	}
+	return errs
+}
+
+// validateCiphertext returns an error if the value does not look like a ciphertext blob of KMS.
+// The value is not included in the error, because users may put the plaintext by mistake.
+func validateCiphertext(path *field.Path, value []byte) field.ErrorList {
+	switch {
+	case len(value) == 0:
+		return field.ErrorList{field.Required(path, "ciphertext must not be empty")}
+	case len(value) > maxCiphertextLength || value[0] != ciphertextVersion:
+		return field.ErrorList{field.Invalid(path, "<omitted>", "value is not a ciphertext blob of KMS, it must be the base64 encoded output of aws kms encrypt")}
+	}
+	return nil
+}
+
+// estimatedSecretSize returns a best-effort estimate of the data size of the generated Secret, so obviously large KMSSecrets are rejected early.
+// A plaintext is shorter than the ciphertext and KMS never encrypts more than 4096 bytes, so the size of each ciphertext is capped.
+// It is not an upper bound, because prefixes of encryptedDataFrom are not counted and templates are counted by the source instead of the rendered output.
+// The controller still rejects the Secret if it is too large after all.
+func estimatedSecretSize(spec *secretv1beta1.KMSSecretSpec) int {
+	size := 0
+	for key, value := range spec.EncryptedData {
+		size += len(key) + plaintextBound(value)
+	}
+	for i := range spec.EncryptedDataFrom {
+		size += plaintextBound(spec.EncryptedDataFrom[i].EncryptedData)
+	}
+	for key, value := range spec.Data {
+		size += len(key) + len(value)
+	}
+	for key, value := range spec.StringData {
+		size += len(key) + len(value)
+	}
+	for key, value := range spec.Template.Data {
+		size += len(key) + len(value)
+	}
+	return size
+}
+
+func plaintextBound(ciphertext []byte) int {
+	if len(ciphertext) > maxPlaintextLength {
+		return maxPlaintextLength
+	}
+	return len(ciphertext)
+}
+
+func knownRegion(region string) bool {
+	for _, p := range endpoints.DefaultPartitions() {
+		if _, ok := p.Regions()[region]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func knownRegions() []string {
+	regions := []string{}
+	for _, p := range endpoints.DefaultPartitions() {
+		for id := range p.Regions() {
+			regions = append(regions, id)
+		}
+	}
+	sort.Strings(regions)
+	return regions
+}
@@ -0,0 +1,265 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	secretv1beta1 "github.com/h3poteto/kms-secrets/api/v1beta1"
+	"github.com/h3poteto/kms-secrets/pkg/decrypter"
+)
+
+// testCiphertext returns a value which looks like a ciphertext blob of KMS.
+func testCiphertext(length int) []byte {
+	return append([]byte{ciphertextVersion}, bytes.Repeat([]byte{0x02}, length-1)...)
+}
+
+func newTestKMSSecret(spec secretv1beta1.KMSSecretSpec) *secretv1beta1.KMSSecret {
+	return &secretv1beta1.KMSSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+		},
+		Spec: spec,
+	}
+}
+
+func TestValidateCreate(t *testing.T) {
+	cases := []struct {
+		title    string
+		spec     secretv1beta1.KMSSecretSpec
+		expected string
+	}{
+		{
+			title: "valid",
+			spec: secretv1beta1.KMSSecretSpec{
+				EncryptedData: map[string][]byte{"API_KEY": testCiphertext(64)},
+				Region:        "us-east-1",
+			},
+		},
+		{
+			title: "plaintext data only",
+			spec: secretv1beta1.KMSSecretSpec{
+				StringData: map[string]string{"HOST": "example.com"},
+				Region:     "us-east-1",
+			},
+		},
+		{
+			title:    "empty",
+			spec:     secretv1beta1.KMSSecretSpec{Region: "us-east-1"},
+			expected: "spec.encryptedData: Required value",
+		},
+		{
+			title: "invalid key",
+			spec: secretv1beta1.KMSSecretSpec{
+				EncryptedData: map[string][]byte{"API KEY": testCiphertext(64)},
+				Region:        "us-east-1",
+			},
+			expected: "spec.encryptedData[API KEY]: Invalid value",
+		},
+		{
+			title: "invalid template key",
+			spec: secretv1beta1.KMSSecretSpec{
+				EncryptedData: map[string][]byte{"API_KEY": testCiphertext(64)},
+				Template:      secretv1beta1.SecretTemplateSpec{Data: map[string]string{"config/yaml": "{{ .API_KEY }}"}},
+				Region:        "us-east-1",
+			},
+			expected: "spec.template.data[config/yaml]: Invalid value",
+		},
+		{
+			title: "same key in data and stringData",
+			spec: secretv1beta1.KMSSecretSpec{
+				Data:       map[string][]byte{"HOST": []byte("localhost")},
+				StringData: map[string]string{"HOST": "example.com"},
+				Region:     "us-east-1",
+			},
+			expected: "spec.data[HOST]: Forbidden: data and stringData must not have the same key",
+		},
+		{
+			title: "same key in stringData and encryptedData",
+			spec: secretv1beta1.KMSSecretSpec{
+				EncryptedData: map[string][]byte{"API_KEY": testCiphertext(64)},
+				StringData:    map[string]string{"API_KEY": "plaintext"},
+				Region:        "us-east-1",
+			},
+			expected: "spec.stringData[API_KEY]: Forbidden: stringData and encryptedData must not have the same key",
+		},
+		{
+			title: "unknown region",
+			spec: secretv1beta1.KMSSecretSpec{
+				EncryptedData: map[string][]byte{"API_KEY": testCiphertext(64)},
+				Region:        "us-east-9",
+			},
+			expected: "spec.region: Unsupported value",
+		},
+		{
+			title: "plaintext instead of ciphertext",
+			spec: secretv1beta1.KMSSecretSpec{
+				EncryptedData: map[string][]byte{"API_KEY": []byte("this is not encrypted, but long enough")},
+				Region:        "us-east-1",
+			},
+			expected: "spec.encryptedData[API_KEY]: Invalid value: \"<omitted>\"",
+		},
+		{
+			title: "empty ciphertext",
+			spec: secretv1beta1.KMSSecretSpec{
+				EncryptedDataFrom: []secretv1beta1.EncryptedDataFrom{{Name: "db"}},
+				Region:            "us-east-1",
+			},
+			expected: "spec.encryptedDataFrom[0].encryptedData: Required value",
+		},
+		{
+			title: "too large",
+			spec: secretv1beta1.KMSSecretSpec{
+				EncryptedData: map[string][]byte{"API_KEY": testCiphertext(64)},
+				StringData:    map[string]string{"LARGE": strings.Repeat("a", 1024*1024)},
+				Region:        "us-east-1",
+			},
+			expected: "estimated size of the generated Secret must be at most 1048576 bytes",
+		},
+	}
+	v := &KMSSecretValidator{}
+	for _, c := range cases {
+		err := v.ValidateCreate(context.Background(), newTestKMSSecret(c.spec))
+		if c.expected == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", c.title, err)
+			}
+			continue
+		}
+		if !apierrors.IsInvalid(err) || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("%s: error should contain %q: %v", c.title, c.expected, err)
+		}
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	v := &KMSSecretValidator{}
+	old := newTestKMSSecret(secretv1beta1.KMSSecretSpec{
+		EncryptedData: map[string][]byte{"API_KEY": []byte("legacy")},
+		Region:        "us-east-1",
+	})
+
+	// KMSSecrets which were created before the webhook can be updated without changing the spec.
+	updated := old.DeepCopy()
+	updated.Finalizers = []string{secretv1beta1.Finalizer}
+	if err := v.ValidateUpdate(context.Background(), old, updated); err != nil {
+		t.Errorf("update without changing the spec should be allowed: %v", err)
+	}
+
+	updated.Spec.Region = "us-west-2"
+	if err := v.ValidateUpdate(context.Background(), old, updated); !apierrors.IsInvalid(err) {
+		t.Errorf("update of the spec should be validated: %v", err)
+	}
+}
+
+func TestValidateDryRunDecrypt(t *testing.T) {
+	d := decrypter.NewFakeDecrypter()
+	d.AddEntry(testCiphertext(64), decrypter.FakeEntry{Plaintext: []byte("hoge"), KeyID: "expected-key"})
+	d.AddEntry(testCiphertext(80), decrypter.FakeEntry{Plaintext: []byte("fuga"), KeyID: "other-key"})
+	v := &KMSSecretValidator{Decrypter: d}
+
+	kind := newTestKMSSecret(secretv1beta1.KMSSecretSpec{
+		EncryptedData: map[string][]byte{"API_KEY": testCiphertext(64)},
+		Region:        "us-east-1",
+		KeyID:         "expected-key",
+	})
+	if err := v.ValidateCreate(context.Background(), kind); err != nil {
+		t.Errorf("decryptable KMSSecret should be allowed: %v", err)
+	}
+
+	kind.Spec.EncryptedDataFrom = []secretv1beta1.EncryptedDataFrom{
+		{Name: "db", EncryptedData: testCiphertext(80)},
+	}
+	err := v.ValidateCreate(context.Background(), kind)
+	if !apierrors.IsInvalid(err) || !strings.Contains(err.Error(), "spec.encryptedDataFrom[0].encryptedData") || !strings.Contains(err.Error(), "KeyMismatch") {
+		t.Errorf("KMSSecret encrypted with other keys should be rejected: %v", err)
+	}
+	if strings.Contains(err.Error(), "fuga") {
+		t.Errorf("error should not contain the plaintext: %v", err)
+	}
+
+	// Transient errors are allowed, because the controller retries them.
+	kind.Spec.EncryptedDataFrom = nil
+	kind.Spec.EncryptedData["UNKNOWN"] = testCiphertext(96)
+	if err := v.ValidateCreate(context.Background(), kind); err != nil {
+		t.Errorf("transient errors should be allowed: %v", err)
+	}
+}
+
+// errorDecrypter fails every decryption with the error.
+type errorDecrypter struct {
+	err error
+}
+
+func (e errorDecrypter) Decrypt(ctx context.Context, input *decrypter.Input) (*decrypter.Output, error) {
+	return nil, e.err
+}
+
+func TestValidateDryRunDecryptIncorrectKey(t *testing.T) {
+	v := &KMSSecretValidator{
+		Decrypter: errorDecrypter{err: awserr.New(kms.ErrCodeIncorrectKeyException, "The key ID in the request does not identify a CMK that can perform this operation.", nil)},
+	}
+	kind := newTestKMSSecret(secretv1beta1.KMSSecretSpec{
+		EncryptedData: map[string][]byte{"API_KEY": testCiphertext(64)},
+		Region:        "us-east-1",
+		KeyID:         "expected-key",
+	})
+	err := v.ValidateCreate(context.Background(), kind)
+	if !apierrors.IsInvalid(err) || !strings.Contains(err.Error(), "spec.encryptedData[API_KEY]") || !strings.Contains(err.Error(), kms.ErrCodeIncorrectKeyException) {
+		t.Errorf("KMSSecret encrypted with other keys than keyID should be rejected: %v", err)
+	}
+}
+
+// regionDecrypter records the region of the last decryption, and fails it.
+type regionDecrypter struct {
+	region string
+}
+
+func (r *regionDecrypter) Decrypt(ctx context.Context, input *decrypter.Input) (*decrypter.Output, error) {
+	r.region = input.Region
+	return nil, awserr.New(kms.ErrCodeDisabledException, "key is disabled", nil)
+}
+
+func TestValidateDryRunDecryptRegion(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "tokyo",
+			Annotations: map[string]string{secretv1beta1.RegionAnnotation: "ap-northeast-1"},
+		}},
+	).Build()
+	d := &regionDecrypter{}
+	v := &KMSSecretValidator{Decrypter: d, Client: c}
+
+	kind := newTestKMSSecret(secretv1beta1.KMSSecretSpec{
+		EncryptedData: map[string][]byte{"API_KEY": testCiphertext(64)},
+	})
+	kind.Namespace = "tokyo"
+	if err := v.ValidateCreate(context.Background(), kind); !apierrors.IsInvalid(err) || d.region != "ap-northeast-1" {
+		t.Errorf("KMSSecret should be decrypted in the region of the namespace, but %q: %v", d.region, err)
+	}
+
+	kind.Namespace = "default"
+	err := v.ValidateCreate(context.Background(), kind)
+	if !apierrors.IsInvalid(err) || !strings.Contains(err.Error(), "spec.region: Required value") {
+		t.Errorf("KMSSecret without any region should be rejected: %v", err)
+	}
+
+	v.DefaultRegion = "us-east-1"
+	if err := v.ValidateCreate(context.Background(), kind); !apierrors.IsInvalid(err) || d.region != "us-east-1" {
+		t.Errorf("KMSSecret should be decrypted in the default region, but %q: %v", d.region, err)
+	}
+}